@@ -0,0 +1,94 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log/slog"
+    "strings"
+)
+
+// annotateCommand implements "gddns annotate", which sets the comment of the
+// managed address records, e.g. to mark a maintenance window, and leaves
+// everything else about them as it is. The next update of a record replaces
+// the comment with comment_template again.
+func annotateCommand(args []string) error {
+    fs := newFlagSet("annotate")
+    comment := fs.String("comment", "", "the comment to set on the records")
+    clearComment := fs.Bool("clear", false, "remove the comment instead of setting one")
+    name := fs.String("record", "", "only annotate this record, by cname or full name, e.g. home or home.example.com")
+    fs.BoolVar(&dryRun, "dry-run", false, "print the comments that would be set without changing the records")
+    if err := start(fs, args); err != nil {
+        return err
+    }
+    if (*comment == "") == !*clearComment {
+        return configError(errors.New("set either --comment or --clear"))
+    }
+
+    unlock, err := lockInstance()
+    if unlock == nil {
+        return err
+    }
+    defer unlock()
+
+    clients, config, err := setup()
+    if err != nil {
+        return err
+    }
+    ctx, stop := signalContext()
+    defer stop()
+
+    found := false
+    for _, rec := range config.Records {
+        if *name != "" && !strings.EqualFold(*name, rec.CNAME) && !strings.EqualFold(*name, rec.fqdn()) {
+            continue
+        }
+        found = true
+        if config.provider(rec.Credentials) == providerDigitalOcean {
+            return configError(fmt.Errorf("%s is on DigitalOcean, which has no record comments", rec.fqdn()))
+        }
+
+        api, err := clients.forRecord(rec)
+        if err != nil {
+            return err
+        }
+        for _, recordType := range rec.recordTypes() {
+            if rec.ZoneID == "" || rec.recordID(recordType) == "" {
+                slog.Warn("record not created yet, run gddns update first", "record", rec.CNAME, "type", recordType)
+                continue
+            }
+            if err := annotateRecord(ctx, api, config, rec, recordType, *comment); err != nil {
+                return fmt.Errorf("annotating %s %s record: %w", rec.fqdn(), recordType, apiError(err))
+            }
+        }
+    }
+    if !found {
+        return configError(fmt.Errorf("no record named %s in the config", *name))
+    }
+    return nil
+}
+
+// annotateRecord sets the comment of the recordType record of rec. The
+// update sends the record as Cloudflare has it, so its content, TTL, proxied
+// flag and tags stay what they are.
+func annotateRecord(ctx context.Context, api DNSProvider, config *Config, rec *Record, recordType, comment string) error {
+    current, err := getRecord(ctx, api, config, rec, recordType)
+    if err != nil {
+        return err
+    }
+    if dryRun {
+        slog.Info("dry run, comment not set", "record", rec.CNAME, "type", recordType, "record_id", current.ID, "comment", comment)
+        return nil
+    }
+    err = withRetry(ctx, config, "update DNS record", func(ctx context.Context) error {
+        record := current
+        record.Comment = comment
+        return api.UpdateRecord(ctx, rec.ZoneID, record)
+    })
+    if err != nil {
+        return err
+    }
+    logEvent("record_annotated", "DNS record comment set", "record", rec.CNAME, "type", recordType,
+        "record_id", current.ID, "comment", comment)
+    return nil
+}
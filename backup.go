@@ -0,0 +1,146 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log/slog"
+    "os"
+    "path/filepath"
+    "time"
+)
+
+// recordBackup is what backup_on_adopt writes before gddns first overwrites
+// a record it did not create.
+type recordBackup struct {
+    Saved  string    `json:"saved"`
+    ZoneID string    `json:"zone_id"`
+    Record dnsRecord `json:"record"`
+}
+
+const backupPrefix = "backup-"
+
+// backupPath is backup-<record id>.json next to the config file.
+func backupPath(config *Config, id string) string {
+    return filepath.Join(filepath.Dir(config.file()), backupPrefix+id+".json")
+}
+
+// backupRecord saves record so "gddns restore" can put it back. An existing
+// backup is kept, it holds the content from before gddns first touched it.
+func backupRecord(config *Config, rec *Record, record dnsRecord) error {
+    if dryRun {
+        return nil
+    }
+    path := backupPath(config, record.ID)
+    if _, err := os.Stat(path); err == nil {
+        slog.Info("record backup already exists, keeping it", "record", rec.CNAME, "path", path)
+        return nil
+    }
+
+    data, err := json.MarshalIndent(recordBackup{
+        Saved:  time.Now().UTC().Format(time.RFC3339),
+        ZoneID: rec.ZoneID,
+        Record: record,
+    }, "", "  ")
+    if err != nil {
+        return err
+    }
+    if err := writeFileAtomic(path, data, 0600); err != nil {
+        return err
+    }
+    slog.Info("saved record backup", "record", rec.CNAME, "type", record.Type, "record_id", record.ID, "path", path)
+    return nil
+}
+
+// restoreCommand implements "gddns restore <file>", which writes a record
+// backup back to Cloudflare.
+func restoreCommand(args []string) error {
+    fs := newFlagSet("restore")
+    fs.BoolVar(&dryRun, "dry-run", false, "print the record that would be restored without changing it")
+    if err := start(fs, args); err != nil {
+        return err
+    }
+    if fs.NArg() != 1 {
+        return configError(errors.New("usage: gddns restore [flags] <backup file>"))
+    }
+
+    data, err := os.ReadFile(fs.Arg(0))
+    if err != nil {
+        return configError(err)
+    }
+    var backup recordBackup
+    if err := json.Unmarshal(data, &backup); err != nil {
+        return configError(fmt.Errorf("parsing %s: %w", fs.Arg(0), err))
+    }
+    if backup.ZoneID == "" || backup.Record.ID == "" {
+        return configError(fmt.Errorf("%s is not a gddns record backup", fs.Arg(0)))
+    }
+
+    unlock, err := lockInstance()
+    if unlock == nil {
+        return err
+    }
+    defer unlock()
+
+    clients, config, err := setup()
+    if err != nil {
+        return err
+    }
+    rec := backupOwner(config, backup)
+    api, err := clients.forRecord(rec)
+    if err != nil {
+        return err
+    }
+
+    record := backup.Record
+    spec := recordSpec{
+        Type:     record.Type,
+        Name:     record.Name,
+        Content:  record.Content,
+        Data:     record.Data,
+        Priority: record.Priority,
+        TTL:      record.TTL,
+        Proxied:  record.Proxied,
+        Tags:     record.Tags,
+    }
+    if dryRun {
+        printDryRun("update", spec)
+        return nil
+    }
+
+    ctx, stop := signalContext()
+    defer stop()
+
+    err = withRetry(ctx, config, "update DNS record", func(ctx context.Context) error {
+        return api.UpdateRecord(ctx, backup.ZoneID, spec.record(record.ID, record.Comment))
+    })
+    if err != nil {
+        return fmt.Errorf("restoring %s record %s: %w", record.Type, record.Name, apiError(err))
+    }
+    logEvent("record_updated", "DNS record restored from backup", "record", record.Name, "type", record.Type,
+        "record_id", record.ID, "ip", record.Content, "backup", fs.Arg(0))
+
+    if rec.recordID(record.Type) == record.ID {
+        slog.Warn("record is still managed by gddns and will be updated again on the next run, remove it from the config to keep the restored content",
+            "record", rec.CNAME)
+    }
+    return nil
+}
+
+// backupOwner returns the configured record the backup belongs to, so the
+// restore uses its credentials. A record that is no longer configured uses
+// the credentials from the environment.
+func backupOwner(config *Config, backup recordBackup) *Record {
+    for _, rec := range config.Records {
+        if rec.ZoneID == backup.ZoneID && rec.recordID(backup.Record.Type) == backup.Record.ID {
+            return rec
+        }
+    }
+    for _, rec := range config.Records {
+        if rec.ZoneID == backup.ZoneID {
+            return &Record{ZoneID: backup.ZoneID, Credentials: rec.Credentials}
+        }
+    }
+    return &Record{ZoneID: backup.ZoneID}
+}
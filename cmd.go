@@ -0,0 +1,393 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "log/slog"
+    "os"
+    "os/signal"
+    "strings"
+    "sync"
+    "syscall"
+    "text/tabwriter"
+)
+
+// commands maps subcommand names to their entry points. Running gddns without
+// a subcommand is the same as "gddns update".
+var commands = map[string]func(args []string) error{
+    "init":        initCommand,
+    "update":      updateCommand,
+    "status":      statusCommand,
+    "list":        listCommand,
+    "delete":      deleteCommand,
+    "annotate":    annotateCommand,
+    "config":      configCommand,
+    "restore":     restoreCommand,
+    "test-notify": testNotifyCommand,
+    "version":     versionCommand,
+}
+
+func usage() {
+    fmt.Fprintln(os.Stderr, "usage: gddns [init|update|status|list|delete|annotate|config show|restore|test-notify|version] [flags]")
+    fmt.Fprintln(os.Stderr, "run \"gddns <command> -h\" for the flags of a command")
+}
+
+// newFlagSet returns a flag set for the named subcommand with the flags
+// shared by every subcommand already registered.
+func newFlagSet(name string) *flag.FlagSet {
+    fs := flag.NewFlagSet("gddns "+name, flag.ContinueOnError)
+    fs.StringVar(&configFile, "config", "", "path to the config file (.json, .yaml or .toml), overrides the data path, \"-\" reads it from stdin")
+    fs.StringVar(&saveTo, "save-to", "", "where to save the updated config when it was read from stdin, printed to stdout by default")
+    fs.StringVar(&logFormat, "log-format", "text", "log output format, \"text\" or \"json\"")
+    fs.StringVar(&logLevel, "log-level", "info", "minimum log level, \"debug\", \"info\", \"warn\" or \"error\"")
+    fs.BoolVar(&quiet, "quiet", false, "only log record changes, warnings and errors")
+    return fs
+}
+
+// start parses args and does the setup every subcommand needs.
+func start(fs *flag.FlagSet, args []string) error {
+    if err := fs.Parse(args); err != nil {
+        if errors.Is(err, flag.ErrHelp) {
+            return err
+        }
+        return configError(err)
+    }
+    if err := setupLogging(os.Stderr, logFormat, logLevel, quiet); err != nil {
+        return configError(err)
+    }
+
+    path, source, err := resolveDataPath()
+    if err != nil {
+        return configError(err)
+    }
+    dataPath = path
+    if configFile != "" && configDir != "" {
+        return configError(errors.New("--config and --config-dir cannot be used together"))
+    }
+    if saveTo != "" && configFile != stdinConfig {
+        return configError(errors.New("--save-to only applies to a config read from stdin with --config -"))
+    }
+    switch {
+    case configDir != "":
+        slog.Info("using config directory", "path", configDir)
+    case configFile == "":
+        slog.Info("using data path", "path", dataPath, "source", source)
+        fallthrough
+    default:
+        slog.Info("using config file", "path", configPath())
+    }
+
+    if err := loadDotEnv(); err != nil {
+        return configError(fmt.Errorf("loading .env file: %w", err))
+    }
+    return nil
+}
+
+func signalContext() (context.Context, context.CancelFunc) {
+    return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+func updateCommand(args []string) error {
+    fs := newFlagSet("update")
+    fs.BoolVar(&daemonMode, "daemon", false, "keep running and update the record whenever the public IP changes")
+    once := fs.Bool("once", false, "update the records once and exit, the default unless --daemon is given")
+    fs.BoolVar(&dryRun, "dry-run", false, "print the changes that would be made without touching DNS or the config file")
+    fs.BoolVar(&previewCreate, "preview-create", false, "print the records that would be created in full instead of creating them, existing records are still updated")
+    fs.BoolVar(&recreateOnTypeChange, "recreate-on-type-change", false, "delete and recreate a record whose type differs from the configured one instead of updating it in place")
+    fs.BoolVar(&forceUpdate, "force", false, "rewrite the records even when the IP is unchanged, e.g. to re-assert the ttl and proxied settings")
+    fs.StringVar(&metricsAddr, "metrics-addr", "", "serve Prometheus metrics and /healthz on this address in daemon mode, e.g. \":9100\"")
+    ipFlag := fs.String("ip", "", "use this address instead of looking it up, comma separated for both an IPv4 and an IPv6 address")
+    ipStdin := fs.Bool("ip-stdin", false, "read the address(es) to use from stdin instead of looking them up")
+    fs.StringVar(&configDir, "config-dir", "", "update the records of every *.json config file in this directory, e.g. /etc/gddns/conf.d")
+    pidFile := fs.String("pidfile", "", "write the process ID to this file while running, e.g. /run/gddns.pid")
+    if err := start(fs, args); err != nil {
+        return err
+    }
+    if *once && daemonMode {
+        return configError(errors.New("--once and --daemon cannot be used together"))
+    }
+    if previewCreate && daemonMode {
+        return configError(errors.New("--preview-create cannot be used with --daemon"))
+    }
+    // Checked before suppliedIPs, which would read the config off stdin
+    if *ipStdin && configFile == stdinConfig {
+        return configError(errors.New("--ip-stdin cannot be used with --config -"))
+    }
+    if (*ipFlag != "" || *ipStdin) && daemonMode {
+        return configError(errors.New("--ip and --ip-stdin cannot be used with --daemon"))
+    }
+    supplied, err := suppliedIPs(*ipFlag, *ipStdin)
+    if err != nil {
+        return configError(err)
+    }
+
+    unlock, err := lockInstance()
+    if unlock == nil {
+        return err
+    }
+    defer unlock()
+
+    if *pidFile != "" {
+        removePIDFile, err := writePIDFile(*pidFile)
+        if err != nil {
+            return configError(err)
+        }
+        defer removePIDFile()
+    }
+
+    var configs []*Config
+    var clients []*clientSet
+    if configDir != "" {
+        if configs, err = loadConfigDir(); err != nil {
+            return err
+        }
+        for _, config := range configs {
+            clients = append(clients, newClientSet(config))
+        }
+    } else {
+        set, config, err := setup()
+        if err != nil {
+            return err
+        }
+        configs, clients = []*Config{config}, []*clientSet{set}
+    }
+
+    ctx, stop := signalContext()
+    defer stop()
+
+    if daemonMode && metricsAddr != "" {
+        // /healthz only knows one schedule and TLS setup, the first config's
+        _, interval, err := daemonSchedule(configs[0])
+        if err != nil {
+            return configError(err)
+        }
+        tlsConfig, err := configs[0].metricsTLS()
+        if err != nil {
+            return configError(err)
+        }
+        serveMetrics(metricsAddr, interval, tlsConfig)
+    }
+    if len(configs) == 1 {
+        return updateConfig(ctx, clients[0], configs[0], supplied)
+    }
+
+    // Every config runs on its own, in daemon mode each with its own interval
+    var (
+        mu       sync.Mutex
+        failures []error
+        wg       sync.WaitGroup
+    )
+    for i, config := range configs {
+        config, clients := config, clients[i]
+        run := func() {
+            if err := updateConfig(ctx, clients, config, supplied); err != nil {
+                logError("error updating config file", err, "path", config.path)
+                mu.Lock()
+                failures = append(failures, fmt.Errorf("%s: %w", config.path, err))
+                mu.Unlock()
+            }
+        }
+        if !daemonMode {
+            run()
+            continue
+        }
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            run()
+        }()
+    }
+    wg.Wait()
+    return errors.Join(failures...)
+}
+
+// updateConfig runs the update, or the daemon, for the records of one config
+// file with the clients made for it.
+func updateConfig(ctx context.Context, clients *clientSet, config *Config, supplied []string) error {
+    if err := useSuppliedIPs(config, supplied); err != nil {
+        return configError(err)
+    }
+    if daemonMode {
+        if err := waitStartupJitter(ctx, config); err != nil {
+            // Stopped before the first check, nothing to clean up
+            slog.Info("shutting down cleanly")
+            return nil
+        }
+    }
+    if dryRun {
+        if err := preflight(ctx, clients, config); err != nil {
+            return err
+        }
+    } else if err := clients.verifyTokens(ctx); err != nil {
+        return err
+    }
+    if !daemonMode {
+        if err := fetchPublicIPs(ctx, config); err != nil {
+            return fmt.Errorf("getting public IP: %w", networkError(err))
+        }
+        return runOnce(ctx, clients, config, forceUpdate)
+    }
+
+    sched, _, err := daemonSchedule(config)
+    if err != nil {
+        return configError(err)
+    }
+
+    // A failed first pass is retried on the next cycle like any other, and a
+    // failed IP lookup counts towards the backoff
+    ipOK := true
+    if err := fetchPublicIPs(ctx, config); err != nil {
+        ipOK = false
+        errorsTotal.WithLabelValues("ip_fetch").Inc()
+        err = fmt.Errorf("getting public IP: %w", err)
+        logError("first update failed, retrying on the next cycle", err)
+        health.failed(err)
+    } else if err := runOnce(ctx, clients, config, forceUpdate); err != nil {
+        logError("first update failed, retrying on the next cycle", err)
+        health.failed(err)
+    } else {
+        health.succeeded(config)
+    }
+    runDaemon(ctx, clients, config, sched, ipOK)
+    return nil
+}
+
+// lockInstance takes the instance lock for a command that changes DNS or the
+// config. A nil unlock function means the command must stop and return err,
+// which is nil when another instance simply got there first.
+func lockInstance() (unlock func(), err error) {
+    unlock, err = acquireLock()
+    if errors.Is(err, errLocked) {
+        slog.Info("another instance is running, exiting", "lock", lockPath())
+        return nil, nil
+    }
+    if err != nil {
+        return nil, configError(err)
+    }
+    return unlock, nil
+}
+
+// suppliedIPs returns the addresses given with --ip or --ip-stdin, for router
+// hooks that already know the address.
+func suppliedIPs(ipFlag string, ipStdin bool) ([]string, error) {
+    if ipFlag != "" && ipStdin {
+        return nil, errors.New("--ip and --ip-stdin cannot be used together")
+    }
+    if ipFlag != "" {
+        return strings.Split(ipFlag, ","), nil
+    }
+    if !ipStdin {
+        return nil, nil
+    }
+
+    data, err := io.ReadAll(os.Stdin)
+    if err != nil {
+        return nil, fmt.Errorf("reading the IP from stdin: %w", err)
+    }
+    ips := strings.Fields(string(data))
+    if len(ips) == 0 {
+        return nil, errors.New("no IP address on stdin")
+    }
+    return ips, nil
+}
+
+// statusCommand prints what each managed record currently points at next to
+// the current public IP, without changing anything.
+func statusCommand(args []string) error {
+    fs := newFlagSet("status")
+    if err := start(fs, args); err != nil {
+        return err
+    }
+
+    clients, config, err := setup()
+    if err != nil {
+        return err
+    }
+
+    ctx, stop := signalContext()
+    defer stop()
+
+    if err := fetchPublicIPs(ctx, config); err != nil {
+        return fmt.Errorf("getting public IP: %w", networkError(err))
+    }
+
+    w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+    fmt.Fprintln(w, "NAME\tTYPE\tRECORD ID\tCONTENT\tTTL\tPUBLIC IP\tSTATE")
+    for _, rec := range config.Records {
+        for _, recordType := range rec.recordTypes() {
+            id := rec.recordID(recordType)
+            content, ttl, state := "-", "-", "not created"
+            if id != "" {
+                var record dnsRecord
+                api, err := clients.forRecord(rec)
+                if err == nil {
+                    record, err = getRecord(ctx, api, config, rec, recordType)
+                }
+                switch {
+                case err != nil:
+                    state = "error: " + err.Error()
+                case record.Content == config.content(rec, recordType):
+                    content, ttl, state = record.Content, TTL(record.TTL).String(), "current"
+                default:
+                    content, ttl, state = record.Content, TTL(record.TTL).String(), "outdated"
+                }
+            } else {
+                id = "-"
+            }
+            fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", rec.CNAME, recordType, id, content, ttl, config.content(rec, recordType), state)
+        }
+    }
+    return w.Flush()
+}
+
+// deleteCommand removes every managed record and clears its ID from the config.
+func deleteCommand(args []string) error {
+    fs := newFlagSet("delete")
+    fs.BoolVar(&dryRun, "dry-run", false, "print the records that would be deleted without deleting them")
+    if err := start(fs, args); err != nil {
+        return err
+    }
+
+    unlock, err := lockInstance()
+    if unlock == nil {
+        return err
+    }
+    defer unlock()
+
+    clients, config, err := setup()
+    if err != nil {
+        return err
+    }
+
+    ctx, stop := signalContext()
+    defer stop()
+
+    for _, rec := range config.Records {
+        api, err := clients.forRecord(rec)
+        if err != nil {
+            return err
+        }
+        if err := deleteRecords(ctx, api, config, rec); err != nil {
+            return fmt.Errorf("deleting %s records: %w", rec.fqdn(), apiError(err))
+        }
+    }
+
+    if err := saveChanges(config); err != nil {
+        return fmt.Errorf("saving config: %w", err)
+    }
+    return nil
+}
+
+// getRecord fetches the current state of the managed recordType record.
+func getRecord(ctx context.Context, api DNSProvider, config *Config, rec *Record, recordType string) (dnsRecord, error) {
+    var record dnsRecord
+    err := withRetry(ctx, config, "get DNS record", func(ctx context.Context) error {
+        var err error
+        record, err = api.GetRecord(ctx, rec.ZoneID, rec.recordID(recordType))
+        return err
+    })
+    return record, err
+}
@@ -0,0 +1,710 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log/slog"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+)
+
+type Config struct {
+    *CfgFile
+    Env struct {
+        CFEmail    string
+        CFApiKey   string
+        CFApiToken string
+        SysIP      string
+        SysIPv6    string
+        Interval   string
+        APIBaseURL string
+
+        DOToken      string
+        DOAPIBaseURL string
+    }
+
+    // raw is the config file as read, before ${VAR} expansion.
+    raw *CfgFile
+
+    // path is the file the config was loaded from and is saved to.
+    path string
+}
+
+// file returns the path the config is saved to.
+func (c *Config) file() string {
+    if c.path != "" {
+        return c.path
+    }
+    return configPath()
+}
+
+type CfgFile struct {
+    Version int       `json:"version"`
+    Records []*Record `json:"records"`
+
+    // Credentials are extra named credential sets records can refer to.
+    Credentials map[string]Credentials `json:"credentials,omitempty"`
+    Interval    string                 `json:"interval,omitempty"`
+
+    // Cron runs the daemon at the times of a standard cron expression, e.g.
+    // "0 * * * *", instead of every interval.
+    Cron string `json:"cron,omitempty"`
+
+    // Provider is the DNS provider of the records using the credentials
+    // from the environment, "cloudflare" by default or "digitalocean".
+    Provider string `json:"provider,omitempty"`
+
+    StartupJitter     string `json:"startup_jitter,omitempty"`
+    ForceInterval     string `json:"force_interval,omitempty"`
+    MinUpdateInterval string `json:"min_update_interval,omitempty"`
+    TTL               TTL    `json:"ttl,omitempty"`
+    SRVTTL            TTL    `json:"srv_ttl,omitempty"`
+    Proxied           bool   `json:"proxied,omitempty"`
+
+    // CreateSRV can be set to false to never create the srv records, even
+    // for records that have an srv section.
+    CreateSRV *bool `json:"create_srv,omitempty"`
+
+    // BackupOnAdopt saves a record that already existed before gddns takes
+    // it over, see "gddns restore".
+    BackupOnAdopt bool `json:"backup_on_adopt,omitempty"`
+
+    IPSource       string   `json:"ip_source,omitempty"`
+    IPSourceStrict bool     `json:"ip_source_strict,omitempty"`
+    IPProviders    []string `json:"ip_providers,omitempty"`
+    IPv6Suffix     string   `json:"ipv6_suffix,omitempty"`
+    AllowPrivateIP bool     `json:"allow_private_ip,omitempty"`
+    IPAllowlist    []string `json:"ip_allowlist,omitempty"`
+    IPDenylist     []string `json:"ip_denylist,omitempty"`
+    RequireBoth    bool     `json:"require_both,omitempty"`
+    IPCacheTTL     string   `json:"ip_cache_ttl,omitempty"`
+    RequestTimeout string   `json:"request_timeout,omitempty"`
+    RetryAttempts  int      `json:"retry_attempts,omitempty"`
+    RetryBaseDelay string   `json:"retry_base_delay,omitempty"`
+    RateLimit      float64  `json:"rate_limit,omitempty"`
+    Concurrency    int      `json:"concurrency,omitempty"`
+    CFAPIBaseURL   string   `json:"cf_api_base_url,omitempty"`
+    HTTPProxy      string   `json:"http_proxy,omitempty"`
+    UserAgent      string   `json:"user_agent,omitempty"`
+
+    NotifyWebhook  string `json:"notify_webhook,omitempty"`
+    NotifyTemplate string `json:"notify_template,omitempty"`
+    IPHistoryFile  string `json:"ip_history_file,omitempty"`
+
+    // OnChangeCommand is run with the new address as its last argument
+    // whenever a record's address changes.
+    OnChangeCommand []string `json:"on_change_command,omitempty"`
+
+    VerifyTimeout  string `json:"verify_timeout,omitempty"`
+    VerifyResolver string `json:"verify_resolver,omitempty"`
+
+    // MetricsTLSCert and MetricsTLSKey serve --metrics-addr over HTTPS,
+    // MetricsTLSClientCA also requires client certificates signed by it.
+    MetricsTLSCert     string `json:"metrics_tls_cert,omitempty"`
+    MetricsTLSKey      string `json:"metrics_tls_key,omitempty"`
+    MetricsTLSClientCA string `json:"metrics_tls_client_ca,omitempty"`
+
+    // LogFile is rotated once it reaches LogMaxSize megabytes, keeping
+    // LogMaxBackups old files. LogStderr keeps a copy of the log on stderr.
+    LogFile       string `json:"log_file,omitempty"`
+    LogMaxSize    int    `json:"log_max_size,omitempty"`
+    LogMaxBackups int    `json:"log_max_backups,omitempty"`
+    LogStderr     bool   `json:"log_stderr,omitempty"`
+
+    // CommentTemplate may use {{.IP}}, {{.Time}} and {{.Hostname}}, the name
+    // of this machine. It is a pointer so an explicit "" turns comments off.
+    CommentTemplate *string `json:"comment_template,omitempty"`
+
+    // Tags are set on every managed record, ["gddns"] by default. A pointer
+    // so an explicit [] leaves the records untagged.
+    Tags *[]string `json:"tags,omitempty"`
+
+    // Single-record layout used before records was introduced. It is
+    // migrated into Records on load and never written back.
+    Domain     string     `json:"domain,omitempty"`
+    CNAME      string     `json:"cname,omitempty"`
+    ZoneID     string     `json:"zone_id,omitempty"`
+    RecordID   string     `json:"record_id,omitempty"`
+    RecordIDv6 string     `json:"record_id_v6,omitempty"`
+    RecordType string     `json:"record_type,omitempty"`
+    SRV        *SRVConfig `json:"srv,omitempty"`
+    LastIP     string     `json:"last_ip,omitempty"`
+    LastIPv6   string     `json:"last_ip_v6,omitempty"`
+}
+
+// Record is a single managed hostname. Type is "A", "AAAA", "both" or
+// "CNAME", which points the name at CNAMETarget instead of the public IP. TTL
+// and Proxied override the global settings when set.
+type Record struct {
+    Domain     string     `json:"domain"`
+    CNAME      string     `json:"cname"`
+    ZoneID     string     `json:"zone_id"`
+    RecordID   string     `json:"record_id"`
+    RecordIDv6 string     `json:"record_id_v6,omitempty"`
+    Type       string     `json:"type,omitempty"`
+    TTL        TTL        `json:"ttl,omitempty"`
+    Proxied    *bool      `json:"proxied,omitempty"`
+    SRV        *SRVConfig `json:"srv,omitempty"`
+
+    // LastIP and LastIPv6 are only read from configs written before the
+    // addresses moved to state.json, see adoptLegacyState.
+    LastIP   string `json:"last_ip,omitempty"`
+    LastIPv6 string `json:"last_ip_v6,omitempty"`
+
+    // SRVPreset fills in the srv section for a well-known service, e.g.
+    // "minecraft"; an srv section next to it overrides single settings.
+    SRVPreset   string `json:"srv_preset,omitempty"`
+    SRVTTL      TTL    `json:"srv_ttl,omitempty"`
+    SRVRecordID string `json:"srv_record_id,omitempty"`
+    CNAMETarget string `json:"cname_target,omitempty"`
+
+    Static []*StaticRecord `json:"static,omitempty"`
+
+    // Credentials names an entry of the credentials map, empty means the
+    // CF_* environment variables.
+    Credentials string `json:"credentials,omitempty"`
+
+    // cachedZone is set when ZoneID came from the state rather than the
+    // config, so a zone the API no longer knows is looked up again.
+    cachedZone bool
+}
+
+// migrateLegacyRecord moves a top-level single record into Records.
+func (c *CfgFile) migrateLegacyRecord() {
+    if len(c.Records) != 0 || (c.Domain == "" && c.CNAME == "") {
+        return
+    }
+
+    c.Records = []*Record{{
+        Domain:     c.Domain,
+        CNAME:      c.CNAME,
+        ZoneID:     c.ZoneID,
+        RecordID:   c.RecordID,
+        RecordIDv6: c.RecordIDv6,
+        Type:       c.RecordType,
+        SRV:        c.SRV,
+        LastIP:     c.LastIP,
+        LastIPv6:   c.LastIPv6,
+    }}
+    c.Domain, c.CNAME, c.ZoneID = "", "", ""
+    c.RecordID, c.RecordIDv6, c.RecordType = "", "", ""
+    c.SRV = nil
+    c.LastIP, c.LastIPv6 = "", ""
+}
+
+// fqdn returns the full name of the managed record, e.g. home.example.com.
+// A cname of "@" is the domain itself and "*" its wildcard, *.example.com.
+func (r *Record) fqdn() string {
+    if r.CNAME == "@" {
+        return r.Domain
+    }
+    return strings.Join([]string{r.CNAME, r.Domain}, ".")
+}
+
+// wildcard reports whether the record is a wildcard such as *.example.com.
+func (r *Record) wildcard() bool {
+    return r.CNAME == "*" || strings.HasPrefix(r.CNAME, "*.")
+}
+
+// recordTypes returns the record types managed for the record's type, which
+// is "A", "AAAA", "both" or "CNAME".
+func (r *Record) recordTypes() []string {
+    switch r.Type {
+    case "", "A":
+        return []string{"A"}
+    case "AAAA":
+        return []string{"AAAA"}
+    case "CNAME":
+        return []string{"CNAME"}
+    default:
+        return []string{"A", "AAAA"}
+    }
+}
+
+func (r *Record) recordID(recordType string) string {
+    if recordType == "AAAA" {
+        return r.RecordIDv6
+    }
+    return r.RecordID
+}
+
+func (r *Record) setRecordID(recordType, id string) {
+    if recordType == "AAAA" {
+        r.RecordIDv6 = id
+        return
+    }
+    r.RecordID = id
+}
+
+// recordTypes returns every address record type used by any record. It is
+// empty when every record is a CNAME, no public IP is needed then.
+func (c *Config) recordTypes() []string {
+    var hasA, hasAAAA bool
+    for _, rec := range c.Records {
+        for _, recordType := range rec.recordTypes() {
+            switch recordType {
+            case "A":
+                hasA = true
+            case "AAAA":
+                hasAAAA = true
+            }
+        }
+    }
+
+    var types []string
+    if hasA {
+        types = append(types, "A")
+    }
+    if hasAAAA {
+        types = append(types, "AAAA")
+    }
+    return types
+}
+
+const defaultTTL = 120
+
+// TTL is a record TTL in seconds. Cloudflare's automatic TTL is 1, which
+// the config also accepts, and writes, as "auto".
+type TTL int
+
+const autoTTL TTL = 1
+
+func (t TTL) String() string {
+    if t == autoTTL {
+        return "auto"
+    }
+    return strconv.Itoa(int(t))
+}
+
+func (t TTL) MarshalJSON() ([]byte, error) {
+    if t == autoTTL {
+        return []byte(`"auto"`), nil
+    }
+    return json.Marshal(int(t))
+}
+
+func (t *TTL) UnmarshalJSON(data []byte) error {
+    var s string
+    if err := json.Unmarshal(data, &s); err == nil {
+        if s != "auto" {
+            return fmt.Errorf("invalid ttl %q, expected \"auto\" or a number of seconds", s)
+        }
+        *t = autoTTL
+        return nil
+    }
+
+    var n int
+    if err := json.Unmarshal(data, &n); err != nil {
+        return fmt.Errorf("invalid ttl %s, expected \"auto\" or a number of seconds", data)
+    }
+    *t = TTL(n)
+    return nil
+}
+
+// recordTTL returns the TTL for rec, falling back to the global ttl and then
+// to defaultTTL. A TTL of 1 means "auto" to Cloudflare.
+func (c *Config) recordTTL(rec *Record) int {
+    if rec.TTL != 0 {
+        return int(rec.TTL)
+    }
+    if c.TTL != 0 {
+        return int(c.TTL)
+    }
+    return defaultTTL
+}
+
+// srvTTL returns the TTL of the SRV record of rec: its own srv_ttl, the global
+// srv_ttl, or the TTL of its address record.
+func (c *Config) srvTTL(rec *Record) int {
+    if rec.SRVTTL != 0 {
+        return int(rec.SRVTTL)
+    }
+    if c.SRVTTL != 0 {
+        return int(c.SRVTTL)
+    }
+    return c.recordTTL(rec)
+}
+
+// recordProxied returns whether rec goes through the Cloudflare proxy. The
+// record's own proxied setting wins over the global one, which defaults to off.
+func (c *Config) recordProxied(rec *Record) bool {
+    if rec.Proxied != nil {
+        return *rec.Proxied
+    }
+    return c.Proxied
+}
+
+func (c *Config) createSRV() bool {
+    return c.CreateSRV == nil || *c.CreateSRV
+}
+
+func validTTL(ttl TTL) bool {
+    return ttl == 0 || ttl == autoTTL || (ttl >= 60 && ttl <= 86400)
+}
+
+const defaultRequestTimeout = 10 * time.Second
+
+// requestTimeout bounds every ipify and Cloudflare call. Retries get a fresh
+// timeout per attempt.
+func (c *Config) requestTimeout() time.Duration {
+    timeout, err := time.ParseDuration(c.RequestTimeout)
+    if err != nil || timeout <= 0 {
+        return defaultRequestTimeout
+    }
+    return timeout
+}
+
+func (c *Config) ip(recordType string) string {
+    if recordType == "AAAA" {
+        return c.Env.SysIPv6
+    }
+    return c.Env.SysIP
+}
+
+// requiresFamily reports whether a record manages only recordType, so it
+// cannot do without an address of that family.
+func (c *Config) requiresFamily(recordType string) bool {
+    for _, rec := range c.Records {
+        if types := rec.recordTypes(); len(types) == 1 && types[0] == recordType {
+            return true
+        }
+    }
+    return false
+}
+
+// familyOptional reports whether the records can do without an address of
+// recordType, because only "both" records use it and require_both is unset.
+func (c *Config) familyOptional(recordType string) bool {
+    return !c.RequireBoth && !c.requiresFamily(recordType)
+}
+
+// content returns what the recordType record of rec should point at: the
+// public IP, or the target of a CNAME record.
+func (c *Config) content(rec *Record, recordType string) string {
+    if recordType == "CNAME" {
+        return strings.TrimSuffix(rec.CNAMETarget, ".")
+    }
+    return c.ip(recordType)
+}
+
+func (c *Config) setIP(recordType, ip string) {
+    if recordType == "AAAA" {
+        c.Env.SysIPv6 = ip
+        return
+    }
+    c.Env.SysIP = ip
+}
+
+// stdinConfig is the --config value that reads the config from stdin.
+const stdinConfig = "-"
+
+// saveTo is where saveConfig writes a config that was read from stdin.
+var saveTo string
+
+func loadConfigAndEnv(filename string) (*Config, error) {
+    var data []byte
+    var err error
+    if filename == stdinConfig {
+        data, err = io.ReadAll(os.Stdin)
+    } else {
+        data, err = os.ReadFile(filename)
+    }
+    if err != nil {
+        return nil, err
+    }
+    if data, err = configToJSON(filename, data); err != nil {
+        return nil, fmt.Errorf("parsing %s as %s: %w", filename, strings.ToUpper(configFormat(filename)), err)
+    }
+    var config Config
+    if err := json.Unmarshal(data, &config); err != nil {
+        return nil, err
+    }
+    config.path = filename
+    config.raw = &CfgFile{}
+    if err := json.Unmarshal(data, config.raw); err != nil {
+        return nil, err
+    }
+    if err := config.expandEnv(); err != nil {
+        return nil, err
+    }
+
+    from := config.Version
+    migrated, err := config.migrate()
+    if err != nil {
+        return nil, err
+    }
+    if migrated {
+        config.raw.migrate()
+        slog.Info("migrated config", "from_version", from, "to_version", config.Version)
+    }
+    if err := config.Validate(); err != nil {
+        return nil, err
+    }
+    if err := adoptLegacyState(&config); err != nil {
+        return nil, fmt.Errorf("loading state: %w", err)
+    }
+    useCachedIDs(&config)
+    // A config from stdin has no file to rewrite, saving it would print it
+    // in the middle of loading
+    if migrated && filename == stdinConfig {
+        slog.Info("config on stdin uses an old config version, not rewriting it", "version", from,
+            "current_version", currentConfigVersion)
+    } else if migrated {
+        if err := saveConfig(&config); err != nil {
+            return nil, fmt.Errorf("saving migrated config: %w", err)
+        }
+    }
+
+    if err := config.loadEnv(); err != nil {
+        return nil, err
+    }
+    if config.HTTPProxy == "" {
+        if err := checkProxyEnv(); err != nil {
+            return nil, err
+        }
+    }
+    if config.usesEnvCredentials() && !config.hasEnvCredentials() {
+        if config.provider("") == providerDigitalOcean {
+            return nil, errors.New("DigitalOcean API token is not set, set DIGITALOCEAN_TOKEN (or DIGITALOCEAN_TOKEN_FILE)")
+        }
+        return nil, errors.New("cloudflare API credentials are not set, set CF_API_TOKEN, or both CF_EMAIL and CF_API_KEY (or their _FILE variants)")
+    }
+
+    return &config, nil
+}
+
+// loadEnv reads the credentials and overrides from the environment.
+func (c *Config) loadEnv() error {
+    for _, secret := range []struct {
+        name  string
+        value *string
+    }{
+        {"CF_API_KEY", &c.Env.CFApiKey},
+        {"CF_EMAIL", &c.Env.CFEmail},
+        {"CF_API_TOKEN", &c.Env.CFApiToken},
+        {"DIGITALOCEAN_TOKEN", &c.Env.DOToken},
+    } {
+        var err error
+        if *secret.value, err = secretEnv(secret.name); err != nil {
+            return err
+        }
+    }
+    c.Env.Interval = os.Getenv("GDDNS_INTERVAL")
+    c.Env.APIBaseURL = os.Getenv("CF_API_BASE_URL")
+    c.Env.DOAPIBaseURL = os.Getenv("DIGITALOCEAN_API_BASE_URL")
+    return nil
+}
+
+// usesEnvCredentials reports whether any record uses the credentials from the
+// environment rather than a named set.
+func (c *Config) usesEnvCredentials() bool {
+    for _, rec := range c.Records {
+        if rec.Credentials == "" {
+            return true
+        }
+    }
+    return false
+}
+
+// hasEnvCredentials reports whether the environment has the credentials the
+// provider of the default credential set needs.
+func (c *Config) hasEnvCredentials() bool {
+    if c.provider("") == providerDigitalOcean {
+        return c.Env.DOToken != ""
+    }
+    return c.Env.CFApiToken != "" || (c.Env.CFApiKey != "" && c.Env.CFEmail != "")
+}
+
+// secretEnv reads the environment variable name, or the file named by
+// name_FILE if that is set, as with Docker and Kubernetes secrets.
+func secretEnv(name string) (string, error) {
+    filename := os.Getenv(name + "_FILE")
+    if filename == "" {
+        return os.Getenv(name), nil
+    }
+
+    data, err := os.ReadFile(filename)
+    if err != nil {
+        return "", fmt.Errorf("reading %s_FILE: %w", name, err)
+    }
+    return strings.TrimSpace(string(data)), nil
+}
+
+// apiBaseURL returns the Cloudflare API endpoint override, CF_API_BASE_URL
+// winning over cf_api_base_url. Empty means the public API.
+func (c *Config) apiBaseURL() string {
+    if c.Env.APIBaseURL != "" {
+        return c.Env.APIBaseURL
+    }
+    return c.CFAPIBaseURL
+}
+
+func saveConfig(config *Config) error {
+    if dryRun {
+        slog.Info("dry run, not saving config")
+        return nil
+    }
+
+    cfgdata := CfgFile{
+        Version: currentConfigVersion,
+        Records: config.Records,
+
+        Credentials: config.Credentials,
+        Interval:    config.Interval,
+
+        Cron: config.Cron,
+
+        Provider: config.Provider,
+
+        StartupJitter:     config.StartupJitter,
+        ForceInterval:     config.ForceInterval,
+        MinUpdateInterval: config.MinUpdateInterval,
+        TTL:               config.TTL,
+        SRVTTL:            config.SRVTTL,
+        Proxied:           config.Proxied,
+
+        CreateSRV:     config.CreateSRV,
+        BackupOnAdopt: config.BackupOnAdopt,
+
+        IPSource:       config.IPSource,
+        IPSourceStrict: config.IPSourceStrict,
+        IPProviders:    config.IPProviders,
+        IPv6Suffix:     config.IPv6Suffix,
+        AllowPrivateIP: config.AllowPrivateIP,
+        IPAllowlist:    config.IPAllowlist,
+        IPDenylist:     config.IPDenylist,
+        RequireBoth:    config.RequireBoth,
+        IPCacheTTL:     config.IPCacheTTL,
+        RequestTimeout: config.RequestTimeout,
+        RetryAttempts:  config.RetryAttempts,
+        RetryBaseDelay: config.RetryBaseDelay,
+        RateLimit:      config.RateLimit,
+        Concurrency:    config.Concurrency,
+        CFAPIBaseURL:   config.CFAPIBaseURL,
+        HTTPProxy:      config.HTTPProxy,
+        UserAgent:      config.UserAgent,
+
+        NotifyWebhook:  config.NotifyWebhook,
+        NotifyTemplate: config.NotifyTemplate,
+        IPHistoryFile:  config.IPHistoryFile,
+
+        OnChangeCommand: config.OnChangeCommand,
+
+        VerifyTimeout:  config.VerifyTimeout,
+        VerifyResolver: config.VerifyResolver,
+
+        MetricsTLSCert:     config.MetricsTLSCert,
+        MetricsTLSKey:      config.MetricsTLSKey,
+        MetricsTLSClientCA: config.MetricsTLSClientCA,
+
+        LogFile:       config.LogFile,
+        LogMaxSize:    config.LogMaxSize,
+        LogMaxBackups: config.LogMaxBackups,
+        LogStderr:     config.LogStderr,
+
+        CommentTemplate: config.CommentTemplate,
+        Tags:            config.Tags,
+    }
+    if config.raw != nil {
+        // Work on a copy, the records are shared with the live config
+        var restored CfgFile
+        data, err := json.Marshal(cfgdata)
+        if err != nil {
+            return err
+        }
+        if err := json.Unmarshal(data, &restored); err != nil {
+            return err
+        }
+        restored.restoreEnvRefs(config.raw)
+        cfgdata = restored
+    }
+
+    target := config.file()
+    if target == stdinConfig {
+        if saveTo == "" {
+            slog.Warn("config was read from stdin, printing the updated config to stdout instead of saving it; " +
+                "record IDs are only kept with --save-to or a writable --config")
+        }
+        target = saveTo
+    }
+
+    data, err := json.MarshalIndent(cfgdata, "", "  ")
+    if err != nil {
+        return err
+    }
+    if target != "" && configUnchanged(target, cfgdata) {
+        slog.Debug("config unchanged, not rewriting it", "path", target)
+        return nil
+    }
+    if data, err = configFromJSON(target, data); err != nil {
+        return err
+    }
+
+    if target == "" {
+        _, err = os.Stdout.Write(append(data, '\n'))
+        return err
+    }
+    return writeFileAtomic(target, data, 0600)
+}
+
+// configUnchanged reports whether the config file at path already holds
+// cfgdata, so an update that only moved the state does not rewrite a file the
+// user may have formatted by hand or made read-only.
+func configUnchanged(path string, cfgdata CfgFile) bool {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return false
+    }
+    if data, err = configToJSON(path, data); err != nil {
+        return false
+    }
+    var current CfgFile
+    if err := json.Unmarshal(data, &current); err != nil {
+        return false
+    }
+    // Left over from before state.json, ignored once the state has them
+    for _, rec := range current.Records {
+        rec.LastIP, rec.LastIPv6 = "", ""
+    }
+
+    have, err := json.Marshal(current)
+    if err != nil {
+        return false
+    }
+    want, err := json.Marshal(cfgdata)
+    return err == nil && bytes.Equal(have, want)
+}
+
+// writeFileAtomic writes data to a temporary file next to filename and renames
+// it into place, so a crash or a concurrent save never leaves a truncated file.
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+    tmp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+
+    if err := tmp.Chmod(perm); err != nil {
+        tmp.Close()
+        return err
+    }
+    if _, err := tmp.Write(data); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Sync(); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+
+    return os.Rename(tmp.Name(), filename)
+}
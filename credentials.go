@@ -0,0 +1,202 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    cloudflare "github.com/cloudflare/cloudflare-go"
+    "log/slog"
+    "sync"
+)
+
+// Credentials is a named credential set from the credentials map, for records
+// in zones of another account or another provider. Either APIToken or, on
+// Cloudflare, both Email and APIKey must be set. Provider defaults to the
+// global provider.
+type Credentials struct {
+    Provider string `json:"provider,omitempty"`
+    APIToken string `json:"api_token,omitempty"`
+    Email    string `json:"email,omitempty"`
+    APIKey   string `json:"api_key,omitempty"`
+}
+
+func (c Credentials) validate(provider string) error {
+    if provider == providerDigitalOcean {
+        if c.APIToken == "" {
+            return fmt.Errorf("set api_token, DigitalOcean has no email and API key authentication")
+        }
+        return nil
+    }
+    if c.APIToken == "" && (c.Email == "" || c.APIKey == "") {
+        return fmt.Errorf("set api_token, or both email and api_key")
+    }
+    return nil
+}
+
+const (
+    providerCloudflare   = "cloudflare"
+    providerDigitalOcean = "digitalocean"
+)
+
+// provider returns the DNS provider of the named credential set, the empty
+// name being the credentials from the environment.
+func (c *Config) provider(credentials string) string {
+    if creds, ok := c.Credentials[credentials]; ok && credentials != "" && creds.Provider != "" {
+        return creds.Provider
+    }
+    if c.Provider != "" {
+        return c.Provider
+    }
+    return providerCloudflare
+}
+
+// clientSet hands out one rate limited provider per credential set, creating
+// each the first time a record needs it. The empty name stands for
+// the credentials from the environment.
+type clientSet struct {
+    config *Config
+
+    mu      sync.Mutex
+    clients map[string]DNSProvider
+}
+
+func newClientSet(config *Config) *clientSet {
+    return &clientSet{config: config, clients: make(map[string]DNSProvider)}
+}
+
+// forRecord returns the provider for the credentials rec refers to.
+func (s *clientSet) forRecord(rec *Record) (DNSProvider, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    name := rec.Credentials
+    if client, ok := s.clients[name]; ok {
+        return client, nil
+    }
+
+    creds, err := s.credentials(name)
+    if err != nil {
+        return nil, err
+    }
+    newClient := newCloudflareProvider
+    if s.config.provider(name) == providerDigitalOcean {
+        newClient = newDigitalOceanProvider
+    }
+    client, err := newClient(s.config, creds)
+    if err != nil {
+        if name != "" {
+            err = fmt.Errorf("credentials %q: %w", name, err)
+        }
+        return nil, configError(err)
+    }
+    s.clients[name] = client
+    return client, nil
+}
+
+// credentials returns the named credential set, or the environment's for the
+// empty name.
+func (s *clientSet) credentials(name string) (Credentials, error) {
+    if name == "" && s.config.provider(name) == providerDigitalOcean {
+        return Credentials{APIToken: s.config.Env.DOToken}, nil
+    }
+    if name == "" {
+        return Credentials{APIToken: s.config.Env.CFApiToken, Email: s.config.Env.CFEmail, APIKey: s.config.Env.CFApiKey}, nil
+    }
+    creds, ok := s.config.Credentials[name]
+    if !ok {
+        return Credentials{}, configError(fmt.Errorf("unknown credentials %q", name))
+    }
+    return creds, nil
+}
+
+// verifyTokens checks every API token the records use before the first
+// update, so a revoked or expired token fails at startup rather than as an
+// obscure error on the first write. An email and API key have nothing to
+// verify.
+func (s *clientSet) verifyTokens(ctx context.Context) error {
+    verified := make(map[string]bool)
+    for _, rec := range s.config.Records {
+        if verified[rec.Credentials] {
+            continue
+        }
+        verified[rec.Credentials] = true
+
+        if _, err := s.verifyToken(ctx, rec); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// verifyToken checks the API token of the credentials rec uses. It reports
+// false, and checks nothing, for an email and API key.
+func (s *clientSet) verifyToken(ctx context.Context, rec *Record) (bool, error) {
+    name := rec.Credentials
+    creds, err := s.credentials(name)
+    if err != nil {
+        return false, err
+    }
+    if creds.APIToken == "" {
+        return false, nil
+    }
+    api, err := s.forRecord(rec)
+    if err != nil {
+        return true, err
+    }
+
+    var token tokenStatus
+    err = withRetry(ctx, s.config, "verify API token", func(ctx context.Context) error {
+        var err error
+        token, err = api.VerifyToken(ctx)
+        return err
+    })
+    if err != nil {
+        return true, apiError(fmt.Errorf("verifying API token%s: %w", credentialsLabel(name), err))
+    }
+    if token.Status != "active" {
+        return true, configError(fmt.Errorf("API token%s is not active, its status is %q", credentialsLabel(name), token.Status))
+    }
+    attrs := []any{"credentials", name, "token_id", token.ID}
+    if !token.ExpiresOn.IsZero() {
+        attrs = append(attrs, "expires_on", token.ExpiresOn)
+    }
+    slog.Info("verified API token", attrs...)
+    return true, nil
+}
+
+// credentialsLabel names a credential set in error messages.
+func credentialsLabel(name string) string {
+    if name == "" {
+        return " from the environment"
+    }
+    return fmt.Sprintf(" of credentials %q", name)
+}
+
+func newCloudflareProvider(config *Config, creds Credentials) (DNSProvider, error) {
+    // Retries are handled by withRetry so it can honor Retry-After
+    opts := []cloudflare.Option{
+        cloudflare.HTTPClient(newHTTPClient(config, 0)),
+        cloudflare.UsingRetryPolicy(0, 1, 1),
+    }
+    if baseURL := config.apiBaseURL(); baseURL != "" {
+        if err := validateBaseURL(baseURL); err != nil {
+            return nil, err
+        }
+        opts = append(opts, cloudflare.BaseURL(baseURL))
+    }
+
+    var client *cloudflare.API
+    var err error
+    if creds.APIToken != "" {
+        if creds.APIKey != "" || creds.Email != "" {
+            slog.Warn("both an API token and an email/API key are set, using the API token")
+        }
+        client, err = cloudflare.NewWithAPIToken(creds.APIToken, opts...)
+    } else {
+        client, err = cloudflare.New(creds.APIKey, creds.Email, opts...)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("initializing Cloudflare client: %w", err)
+    }
+
+    return newRateLimitedProvider(&cloudflareProvider{api: client}, config.rateLimit()), nil
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "github.com/robfig/cron/v3"
+    "log/slog"
+    "math/rand"
+    "time"
+)
+
+const (
+    defaultInterval = 5 * time.Minute
+    // ipify asks clients not to poll more often than this.
+    minInterval = 30 * time.Second
+)
+
+// pollInterval resolves the daemon interval, preferring GDDNS_INTERVAL over
+// the config file.
+func pollInterval(config *Config) (time.Duration, error) {
+    raw := config.Interval
+    if config.Env.Interval != "" {
+        raw = config.Env.Interval
+    }
+    if raw == "" {
+        return defaultInterval, nil
+    }
+
+    interval, err := time.ParseDuration(raw)
+    if err != nil {
+        return 0, fmt.Errorf("invalid interval %q: %w", raw, err)
+    }
+    if interval < minInterval {
+        return 0, fmt.Errorf("interval %s is shorter than the minimum of %s", interval, minInterval)
+    }
+
+    return interval, nil
+}
+
+// schedule decides how long the daemon waits before the next cycle, given
+// whether the last one could get a public IP.
+type schedule interface {
+    next(ipOK bool) time.Duration
+}
+
+// cronSchedule runs the cycles at the times of the cron expression, e.g. just
+// after the ISP renews the lease, rather than every interval.
+type cronSchedule struct {
+    expr string
+    spec cron.Schedule
+}
+
+func (s *cronSchedule) next(bool) time.Duration {
+    return time.Until(s.spec.Next(time.Now()))
+}
+
+// cronLookahead is how many upcoming runs of a cron schedule its gaps are
+// taken from.
+const cronLookahead = 100
+
+// gaps returns the shortest and the longest time between two upcoming runs.
+func (s *cronSchedule) gaps() (shortest, longest time.Duration) {
+    run := s.spec.Next(time.Now())
+    for i := 0; i < cronLookahead; i++ {
+        next := s.spec.Next(run)
+        gap := next.Sub(run)
+        if shortest == 0 || gap < shortest {
+            shortest = gap
+        }
+        longest = max(longest, gap)
+        run = next
+    }
+    return shortest, longest
+}
+
+// daemonSchedule returns the schedule of the daemon, cron when set and
+// interval otherwise, along with the longest time between two cycles that
+// /healthz judges staleness by. GDDNS_INTERVAL wins over a cron of the
+// config file, like over its interval.
+func daemonSchedule(config *Config) (schedule, time.Duration, error) {
+    if config.Cron == "" || config.Env.Interval != "" {
+        interval, err := pollInterval(config)
+        if err != nil {
+            return nil, 0, err
+        }
+        return &ipBackoff{interval: interval}, interval, nil
+    }
+
+    spec, err := cron.ParseStandard(config.Cron)
+    if err != nil {
+        return nil, 0, fmt.Errorf("invalid cron %q: %w", config.Cron, err)
+    }
+    sched := &cronSchedule{expr: config.Cron, spec: spec}
+    _, longest := sched.gaps()
+    return sched, longest, nil
+}
+
+// waitStartupJitter sleeps for a random duration up to startup_jitter, so a
+// fleet of hosts rebooting together does not hit the IP providers and
+// Cloudflare all at once.
+func waitStartupJitter(ctx context.Context, config *Config) error {
+    jitter, err := time.ParseDuration(config.StartupJitter)
+    if err != nil || jitter <= 0 {
+        return nil
+    }
+
+    delay := time.Duration(rand.Int63n(int64(jitter)))
+    slog.Info("delaying first check", "delay", delay, "startup_jitter", jitter)
+    select {
+    case <-ctx.Done():
+        return ctx.Err()
+    case <-time.After(delay):
+        return nil
+    }
+}
+
+// forceInterval returns how often the daemon rewrites every record even when
+// the IP did not change. Zero disables it.
+func (c *Config) forceInterval() time.Duration {
+    interval, err := time.ParseDuration(c.ForceInterval)
+    if err != nil || interval <= 0 {
+        return 0
+    }
+    return interval
+}
+
+// minUpdateInterval returns how long after an update gddns holds off changing
+// the same record again, so a link flapping between two addresses does not
+// mean an update every cycle. Zero disables it.
+func (c *Config) minUpdateInterval() time.Duration {
+    interval, err := time.ParseDuration(c.MinUpdateInterval)
+    if err != nil || interval <= 0 {
+        return 0
+    }
+    return interval
+}
+
+const (
+    // ipBackoffAfter is how many cycles in a row may fail to get any public
+    // IP before the daemon starts waiting longer between cycles.
+    ipBackoffAfter = 3
+    maxIPBackoff   = time.Hour
+)
+
+// ipBackoff stretches the daemon interval while the IP providers are down,
+// doubling it for every failed cycle past ipBackoffAfter up to maxIPBackoff,
+// so an outage does not mean an error every interval.
+type ipBackoff struct {
+    interval time.Duration
+    failures int
+}
+
+// next records the outcome of a cycle and returns the delay until the next.
+func (b *ipBackoff) next(ipOK bool) time.Duration {
+    if ipOK {
+        if b.failures >= ipBackoffAfter {
+            slog.Info("public IP lookups recovered, back to the normal interval", "interval", b.interval, "failed_cycles", b.failures)
+        }
+        b.failures = 0
+        return b.interval
+    }
+
+    b.failures++
+    if b.failures < ipBackoffAfter {
+        return b.interval
+    }
+    delay := b.interval
+    for i := ipBackoffAfter; i <= b.failures && delay < maxIPBackoff; i++ {
+        delay *= 2
+    }
+    delay = min(delay, max(maxIPBackoff, b.interval))
+    if b.failures == ipBackoffAfter {
+        slog.Warn("public IP lookups keep failing, backing off", "failed_cycles", b.failures, "next_check", delay)
+    }
+    return delay
+}
+
+// runDaemon runs the update cycles until ctx is done. ipOK is whether the
+// first pass, run by the caller, could get a public IP.
+func runDaemon(ctx context.Context, clients *clientSet, config *Config, sched schedule, ipOK bool) {
+    switch sched := sched.(type) {
+    case *cronSchedule:
+        slog.Info("daemon started", "cron", sched.expr, "version", versionString())
+    case *ipBackoff:
+        slog.Info("daemon started", "interval", sched.interval, "version", versionString())
+    }
+
+    timer := time.NewTimer(sched.next(ipOK))
+    defer timer.Stop()
+
+    forceInterval := config.forceInterval()
+    lastForced := time.Now()
+
+    // pendingSave is set when a record changed but the config has not been
+    // written yet, either because the save failed or the cycle was interrupted.
+    pendingSave := false
+    for {
+        select {
+        case <-ctx.Done():
+            if pendingSave {
+                if err := saveChanges(config); err != nil {
+                    logError("error saving config on shutdown", err)
+                }
+            }
+            slog.Info("shutting down cleanly")
+            return
+        case <-timer.C:
+            force := forceInterval > 0 && time.Since(lastForced) >= forceInterval
+            if force {
+                slog.Info("force_interval elapsed, rewriting every record", "force_interval", forceInterval)
+                lastForced = time.Now()
+            }
+            changed, ipOK := runCycle(ctx, clients, config, force)
+            if changed {
+                pendingSave = true
+            }
+            timer.Reset(sched.next(ipOK))
+            if pendingSave && ctx.Err() == nil {
+                if err := saveChanges(config); err != nil {
+                    logError("error saving config", err)
+                    errorsTotal.WithLabelValues("save_config").Inc()
+                    continue
+                }
+                pendingSave = false
+            }
+        }
+    }
+}
+
+// runCycle checks the public IP once and updates every record that is out of
+// date, or every record when force is set. It reports whether any record
+// changed, and whether at least one address family could be looked up.
+func runCycle(ctx context.Context, clients *clientSet, config *Config, force bool) (changed, ipOK bool) {
+    var cycleErr error
+
+    // Fetch each address family once per cycle and share it across records
+    recordTypes := config.recordTypes()
+    ipOK = len(recordTypes) == 0
+    ips, errs := fetchIPs(ctx, config, recordTypes)
+    for _, recordType := range recordTypes {
+        ip, err := ips[recordType], errs[recordType]
+        if errors.Is(err, errIPRefused) {
+            slog.Warn("public address refused, skipping these records", "type", recordType, "error", err)
+            config.setIP(recordType, "")
+            ipOK = true
+            continue
+        }
+        if err != nil && config.familyOptional(recordType) {
+            slog.Warn("no usable public address, skipping these records", "type", recordType, "error", err)
+            config.setIP(recordType, "")
+            continue
+        }
+        if err != nil {
+            logError("error getting public IP", err, "type", recordType)
+            errorsTotal.WithLabelValues("ip_fetch").Inc()
+            config.setIP(recordType, "")
+            cycleErr = fmt.Errorf("getting public %s address: %w", recordType, err)
+            continue
+        }
+        config.setIP(recordType, ip)
+        ipOK = true
+    }
+    if !ipOK && cycleErr == nil {
+        cycleErr = errors.New("no public address of any family")
+    }
+
+    // The same pass as a single run, so a zone that could not be looked up
+    // yet, a missing SRV record or a changed static record is caught up on
+    changed, err := updateRecords(ctx, clients, config, force)
+    if ctx.Err() != nil {
+        return changed, ipOK
+    }
+    cycleErr = errors.Join(cycleErr, err)
+
+    if cycleErr != nil {
+        health.failed(cycleErr)
+    } else {
+        health.succeeded(config)
+    }
+
+    return changed, ipOK
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "path/filepath"
+    "sync/atomic"
+    "testing"
+)
+
+// A daemon whose first pass could not get a public IP never looked the zone
+// up; a later cycle must still find it and create the records, SRV included.
+func TestRunCycleAfterFailedFirstPass(t *testing.T) {
+    var up atomic.Bool
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if !up.Load() {
+            w.WriteHeader(http.StatusServiceUnavailable)
+            return
+        }
+        w.Write([]byte(testIP))
+    }))
+    t.Cleanup(server.Close)
+
+    config, rec := newTestConfig(t)
+    config.path = filepath.Join(t.TempDir(), "config.json")
+    config.IPProviders = []string{server.URL}
+    config.setIP("A", "")
+    rec.ZoneID = ""
+    rec.SRV = testSRV
+    fake := newFakeDNS()
+    clients := newClientSet(config)
+    clients.clients[""] = &cloudflareProvider{api: fake}
+    ctx := context.Background()
+
+    // What updateConfig does first in daemon mode
+    if err := fetchPublicIPs(ctx, config); err == nil {
+        t.Fatal("fetchPublicIPs() succeeded with the IP provider down")
+    }
+
+    if _, ipOK := runCycle(ctx, clients, config, false); ipOK {
+        t.Error("runCycle() reported a public IP with the IP provider down")
+    }
+    if len(fake.records) != 0 {
+        t.Fatalf("records created without a public IP: %+v", fake.records)
+    }
+
+    up.Store(true)
+    changed, ipOK := runCycle(ctx, clients, config, false)
+    if !ipOK || !changed {
+        t.Errorf("runCycle() = changed %t, ipOK %t, want both", changed, ipOK)
+    }
+    if rec.ZoneID != "zone" {
+        t.Errorf("zone_id = %q, want the discovered zone", rec.ZoneID)
+    }
+    if a := fake.byType("A"); len(a) != 1 || a[0].Content != testIP || rec.RecordID != a[0].ID {
+        t.Errorf("A records = %+v, record_id = %q, want one pointing at %s", a, rec.RecordID, testIP)
+    }
+    if srv := fake.byType("SRV"); len(srv) != 1 || rec.SRVRecordID != srv[0].ID {
+        t.Errorf("SRV records = %+v, srv_record_id = %q, want one with its ID saved", srv, rec.SRVRecordID)
+    }
+    if status := health.status(defaultInterval); !status.Healthy || status.LastError != "" {
+        t.Errorf("health = %+v, want healthy", status)
+    }
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+)
+
+const (
+    defaultDigitalOceanBaseURL = "https://api.digitalocean.com/v2"
+
+    // digitalOceanDefaultTTL stands in for Cloudflare's automatic TTL, which
+    // DigitalOcean does not have.
+    digitalOceanDefaultTTL = 1800
+    digitalOceanPageSize   = 200
+)
+
+// digitalOceanProvider implements DNSProvider with the DigitalOcean domains
+// API. DigitalOcean identifies a domain by its name, which therefore is the
+// zone ID. It has no proxy, record comments or record tags, they are dropped.
+type digitalOceanProvider struct {
+    baseURL string
+    token   string
+    http    *http.Client
+}
+
+var _ DNSProvider = (*digitalOceanProvider)(nil)
+
+func newDigitalOceanProvider(config *Config, creds Credentials) (DNSProvider, error) {
+    if creds.APIToken == "" {
+        return nil, errors.New("DigitalOcean needs an API token")
+    }
+    baseURL := defaultDigitalOceanBaseURL
+    if config.Env.DOAPIBaseURL != "" {
+        if u, err := url.Parse(config.Env.DOAPIBaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+            return nil, fmt.Errorf("invalid DIGITALOCEAN_API_BASE_URL %q, expected an http(s) URL such as %q", config.Env.DOAPIBaseURL, defaultDigitalOceanBaseURL)
+        }
+        baseURL = strings.TrimSuffix(config.Env.DOAPIBaseURL, "/")
+    }
+
+    // Like the Cloudflare client, requests are bounded by withRetry
+    provider := &digitalOceanProvider{baseURL: baseURL, token: creds.APIToken, http: newHTTPClient(config, 0)}
+    return newRateLimitedProvider(provider, config.rateLimit()), nil
+}
+
+// digitalOceanRecord is a record as the DigitalOcean API has it. Name is
+// relative to the domain, "@" for the domain itself.
+type digitalOceanRecord struct {
+    ID       int    `json:"id,omitempty"`
+    Type     string `json:"type"`
+    Name     string `json:"name"`
+    Data     string `json:"data"`
+    Priority *int   `json:"priority"`
+    Port     *int   `json:"port"`
+    Weight   *int   `json:"weight"`
+    TTL      int    `json:"ttl"`
+}
+
+// providerError is an error response of a DNS provider other than
+// Cloudflare, whose client errors are *cloudflare.Error.
+type providerError struct {
+    Provider   string
+    StatusCode int
+    ID         string
+    Message    string
+}
+
+func (e *providerError) Error() string {
+    return fmt.Sprintf("%s API error %d (%s): %s", e.Provider, e.StatusCode, e.ID, e.Message)
+}
+
+// do sends a request to the API and decodes the response into out, if any.
+func (c *digitalOceanProvider) do(ctx context.Context, method, path string, body, out interface{}) error {
+    var reqBody io.Reader
+    if body != nil {
+        data, err := json.Marshal(body)
+        if err != nil {
+            return err
+        }
+        reqBody = bytes.NewReader(data)
+    }
+    req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reqBody)
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Authorization", "Bearer "+c.token)
+    req.Header.Set("Content-Type", "application/json")
+
+    resp, err := c.http.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        apiErr := &providerError{Provider: "digitalocean", StatusCode: resp.StatusCode}
+        var errBody struct {
+            ID      string `json:"id"`
+            Message string `json:"message"`
+        }
+        if json.NewDecoder(resp.Body).Decode(&errBody) == nil {
+            apiErr.ID, apiErr.Message = errBody.ID, errBody.Message
+        }
+        return apiErr
+    }
+    if out == nil {
+        return nil
+    }
+    if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+        return fmt.Errorf("decoding DigitalOcean response: %w", err)
+    }
+    return nil
+}
+
+func (c *digitalOceanProvider) FindRecord(ctx context.Context, zoneID, recordType, name string) ([]dnsRecord, error) {
+    query := url.Values{"per_page": {strconv.Itoa(digitalOceanPageSize)}}
+    if recordType != "" {
+        query.Set("type", recordType)
+    }
+    if name != "" {
+        query.Set("name", name)
+    }
+
+    var records []dnsRecord
+    for page := 1; ; page++ {
+        query.Set("page", strconv.Itoa(page))
+        var resp struct {
+            DomainRecords []digitalOceanRecord `json:"domain_records"`
+        }
+        if err := c.do(ctx, http.MethodGet, c.domainPath(zoneID)+"/records?"+query.Encode(), nil, &resp); err != nil {
+            return nil, domainError(err)
+        }
+        for _, record := range resp.DomainRecords {
+            records = append(records, record.toRecord(zoneID))
+        }
+        if len(resp.DomainRecords) < digitalOceanPageSize {
+            break
+        }
+    }
+    return records, nil
+}
+
+func (c *digitalOceanProvider) GetRecord(ctx context.Context, zoneID, recordID string) (dnsRecord, error) {
+    var resp struct {
+        DomainRecord digitalOceanRecord `json:"domain_record"`
+    }
+    if err := c.do(ctx, http.MethodGet, c.recordPath(zoneID, recordID), nil, &resp); err != nil {
+        return dnsRecord{}, c.recordError(ctx, zoneID, err)
+    }
+    return resp.DomainRecord.toRecord(zoneID), nil
+}
+
+func (c *digitalOceanProvider) CreateRecord(ctx context.Context, zoneID string, record dnsRecord) (dnsRecord, error) {
+    var resp struct {
+        DomainRecord digitalOceanRecord `json:"domain_record"`
+    }
+    if err := c.do(ctx, http.MethodPost, c.domainPath(zoneID)+"/records", newDigitalOceanRecord(zoneID, record), &resp); err != nil {
+        return dnsRecord{}, domainError(err)
+    }
+    return resp.DomainRecord.toRecord(zoneID), nil
+}
+
+func (c *digitalOceanProvider) UpdateRecord(ctx context.Context, zoneID string, record dnsRecord) error {
+    if err := c.do(ctx, http.MethodPut, c.recordPath(zoneID, record.ID), newDigitalOceanRecord(zoneID, record), nil); err != nil {
+        return c.recordError(ctx, zoneID, err)
+    }
+    return nil
+}
+
+func (c *digitalOceanProvider) DeleteRecord(ctx context.Context, zoneID, recordID string) error {
+    if err := c.do(ctx, http.MethodDelete, c.recordPath(zoneID, recordID), nil, nil); err != nil {
+        return c.recordError(ctx, zoneID, err)
+    }
+    return nil
+}
+
+// FindZones looks the domain up by its name. DigitalOcean has one domain of
+// a name per account, so there is at most one.
+func (c *digitalOceanProvider) FindZones(ctx context.Context, name string) ([]dnsZone, error) {
+    zone, err := c.GetZone(ctx, name)
+    if zoneNotFound(err) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    return []dnsZone{zone}, nil
+}
+
+func (c *digitalOceanProvider) GetZone(ctx context.Context, zoneID string) (dnsZone, error) {
+    var resp struct {
+        Domain struct {
+            Name string `json:"name"`
+        } `json:"domain"`
+    }
+    if err := c.do(ctx, http.MethodGet, c.domainPath(zoneID), nil, &resp); err != nil {
+        return dnsZone{}, domainError(err)
+    }
+    return dnsZone{ID: resp.Domain.Name, Name: resp.Domain.Name}, nil
+}
+
+// VerifyToken checks the token by reading the account, DigitalOcean has no
+// endpoint for the token itself. A billing warning still leaves the token
+// usable, only a locked account is reported as such.
+func (c *digitalOceanProvider) VerifyToken(ctx context.Context) (tokenStatus, error) {
+    var resp struct {
+        Account struct {
+            UUID   string `json:"uuid"`
+            Status string `json:"status"`
+        } `json:"account"`
+    }
+    if err := c.do(ctx, http.MethodGet, "/account", nil, &resp); err != nil {
+        return tokenStatus{}, err
+    }
+    status := "active"
+    if resp.Account.Status == "locked" {
+        status = resp.Account.Status
+    }
+    return tokenStatus{ID: resp.Account.UUID, Status: status}, nil
+}
+
+func (c *digitalOceanProvider) domainPath(domain string) string {
+    return "/domains/" + url.PathEscape(domain)
+}
+
+func (c *digitalOceanProvider) recordPath(domain, recordID string) string {
+    return c.domainPath(domain) + "/records/" + url.PathEscape(recordID)
+}
+
+// domainError marks a 404 from a request about the domain as a missing zone.
+func domainError(err error) error {
+    var pErr *providerError
+    if errors.As(err, &pErr) && pErr.StatusCode == http.StatusNotFound {
+        return &notFoundError{zone: true, err: err}
+    }
+    return err
+}
+
+// recordError tells a missing record from a missing domain, DigitalOcean
+// answers a request for one record with a 404 in both cases. The domain is
+// looked up to find out which it is.
+func (c *digitalOceanProvider) recordError(ctx context.Context, domain string, err error) error {
+    var pErr *providerError
+    if !errors.As(err, &pErr) || pErr.StatusCode != http.StatusNotFound {
+        return err
+    }
+    if _, zoneErr := c.GetZone(ctx, domain); zoneNotFound(zoneErr) {
+        return &notFoundError{zone: true, err: err}
+    }
+    return &notFoundError{err: err}
+}
+
+// newDigitalOceanRecord translates record into the request body of a create
+// or update. The SRV settings gddns keeps in Data become fields of their own.
+func newDigitalOceanRecord(domain string, r dnsRecord) digitalOceanRecord {
+    record := digitalOceanRecord{
+        Type: r.Type,
+        Name: relativeName(r.Name, domain),
+        Data: r.Content,
+        TTL:  r.TTL,
+    }
+    if r.TTL == int(autoTTL) || r.TTL == 0 {
+        record.TTL = digitalOceanDefaultTTL
+    }
+    if r.Priority != nil {
+        p := int(*r.Priority)
+        record.Priority = &p
+    }
+
+    switch r.Type {
+    case "CNAME", "MX":
+        record.Data = fullyQualified(r.Content)
+    case "SRV":
+        srv, _ := r.Data.(map[string]interface{})
+        service, _ := srv["service"].(string)
+        proto, _ := srv["proto"].(string)
+        target, _ := srv["target"].(string)
+        record.Name = service + "." + proto
+        if host := relativeName(r.Name, domain); host != "@" {
+            record.Name += "." + host
+        }
+        record.Data = fullyQualified(target)
+        for key, field := range map[string]**int{"priority": &record.Priority, "weight": &record.Weight, "port": &record.Port} {
+            if value, ok := srv[key].(int); ok {
+                *field = &value
+            }
+        }
+    }
+    return record
+}
+
+// toRecord returns r with its full name and, for SRV records, its settings
+// in Data as JSON numbers, the way gddns compares them.
+func (r digitalOceanRecord) toRecord(domain string) dnsRecord {
+    record := dnsRecord{
+        ID:      strconv.Itoa(r.ID),
+        Type:    r.Type,
+        Name:    domain,
+        Content: strings.TrimSuffix(r.Data, "."),
+        TTL:     r.TTL,
+    }
+    if r.Name != "@" {
+        record.Name = r.Name + "." + domain
+    }
+    if r.Priority != nil {
+        p := uint16(*r.Priority)
+        record.Priority = &p
+    }
+
+    if r.Type == "SRV" {
+        labels := strings.SplitN(r.Name, ".", 3)
+        data := map[string]interface{}{"target": record.Content, "name": domain}
+        if len(labels) >= 2 {
+            data["service"], data["proto"] = labels[0], labels[1]
+        }
+        if len(labels) == 3 {
+            data["name"] = labels[2] + "." + domain
+        }
+        for key, value := range map[string]*int{"priority": r.Priority, "weight": r.Weight, "port": r.Port} {
+            if value != nil {
+                data[key] = float64(*value)
+            }
+        }
+        record.Data = data
+    }
+    return record
+}
+
+// relativeName turns the full name of a record into a name relative to
+// domain, "@" for the domain itself.
+func relativeName(name, domain string) string {
+    name = strings.TrimSuffix(name, ".")
+    if strings.EqualFold(name, domain) {
+        return "@"
+    }
+    return strings.TrimSuffix(name, "."+domain)
+}
+
+func fullyQualified(name string) string {
+    if name == "" || strings.HasSuffix(name, ".") {
+        return name
+    }
+    return name + "."
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+    "context"
+    "errors"
+    cloudflare "github.com/cloudflare/cloudflare-go"
+    "net/http"
+    "strings"
+)
+
+// DNSClient is the part of the Cloudflare API gddns uses. *cloudflare.API
+// satisfies it; tests can provide their own.
+type DNSClient interface {
+    ListDNSRecords(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.ListDNSRecordsParams) ([]cloudflare.DNSRecord, *cloudflare.ResultInfo, error)
+    GetDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, recordID string) (cloudflare.DNSRecord, error)
+    CreateDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.CreateDNSRecordParams) (cloudflare.DNSRecord, error)
+    UpdateDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.UpdateDNSRecordParams) (cloudflare.DNSRecord, error)
+    DeleteDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, recordID string) error
+    ListZonesContext(ctx context.Context, opts ...cloudflare.ReqOption) (cloudflare.ZonesResponse, error)
+    ZoneDetails(ctx context.Context, zoneID string) (cloudflare.Zone, error)
+    VerifyAPIToken(ctx context.Context) (cloudflare.APITokenVerifyBody, error)
+}
+
+var _ DNSClient = (*cloudflare.API)(nil)
+
+// cloudflareProvider implements DNSProvider with the Cloudflare API.
+type cloudflareProvider struct {
+    api DNSClient
+}
+
+var _ DNSProvider = (*cloudflareProvider)(nil)
+
+func (p *cloudflareProvider) FindRecord(ctx context.Context, zoneID, recordType, name string) ([]dnsRecord, error) {
+    records, _, err := p.api.ListDNSRecords(ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.ListDNSRecordsParams{
+        Type: recordType,
+        Name: name,
+    })
+    if err != nil {
+        return nil, cloudflareNotFound(err, false)
+    }
+    found := make([]dnsRecord, 0, len(records))
+    for _, record := range records {
+        found = append(found, fromCloudflare(record))
+    }
+    return found, nil
+}
+
+func (p *cloudflareProvider) GetRecord(ctx context.Context, zoneID, recordID string) (dnsRecord, error) {
+    record, err := p.api.GetDNSRecord(ctx, cloudflare.ZoneIdentifier(zoneID), recordID)
+    if err != nil {
+        return dnsRecord{}, cloudflareNotFound(err, true)
+    }
+    return fromCloudflare(record), nil
+}
+
+func (p *cloudflareProvider) CreateRecord(ctx context.Context, zoneID string, record dnsRecord) (dnsRecord, error) {
+    created, err := p.api.CreateDNSRecord(ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.CreateDNSRecordParams{
+        Type:     record.Type,
+        Name:     record.Name,
+        Content:  record.Content,
+        Data:     record.Data,
+        Priority: record.Priority,
+        TTL:      record.TTL,
+        Proxied:  cloudflare.BoolPtr(record.Proxied),
+        Comment:  record.Comment,
+        Tags:     record.Tags,
+    })
+    if err != nil {
+        return dnsRecord{}, cloudflareNotFound(err, false)
+    }
+    return fromCloudflare(created), nil
+}
+
+func (p *cloudflareProvider) UpdateRecord(ctx context.Context, zoneID string, record dnsRecord) error {
+    _, err := p.api.UpdateDNSRecord(ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.UpdateDNSRecordParams{
+        ID:       record.ID,
+        Type:     record.Type,
+        Name:     record.Name,
+        Content:  record.Content,
+        Data:     record.Data,
+        Priority: record.Priority,
+        TTL:      record.TTL,
+        Proxied:  cloudflare.BoolPtr(record.Proxied),
+        Comment:  cloudflare.StringPtr(record.Comment),
+        Tags:     record.Tags,
+    })
+    return cloudflareNotFound(err, true)
+}
+
+func (p *cloudflareProvider) DeleteRecord(ctx context.Context, zoneID, recordID string) error {
+    return cloudflareNotFound(p.api.DeleteDNSRecord(ctx, cloudflare.ZoneIdentifier(zoneID), recordID), true)
+}
+
+func (p *cloudflareProvider) FindZones(ctx context.Context, name string) ([]dnsZone, error) {
+    zones, err := p.api.ListZonesContext(ctx, cloudflare.WithZoneFilters(name, "", ""))
+    if err != nil {
+        return nil, err
+    }
+    var found []dnsZone
+    for _, zone := range zones.Result {
+        if strings.EqualFold(zone.Name, name) {
+            found = append(found, dnsZone{ID: zone.ID, Name: zone.Name, Account: zone.Account.Name})
+        }
+    }
+    return found, nil
+}
+
+func (p *cloudflareProvider) GetZone(ctx context.Context, zoneID string) (dnsZone, error) {
+    zone, err := p.api.ZoneDetails(ctx, zoneID)
+    if err != nil {
+        return dnsZone{}, cloudflareNotFound(err, false)
+    }
+    return dnsZone{ID: zone.ID, Name: zone.Name, Account: zone.Account.Name}, nil
+}
+
+func (p *cloudflareProvider) VerifyToken(ctx context.Context) (tokenStatus, error) {
+    token, err := p.api.VerifyAPIToken(ctx)
+    if err != nil {
+        return tokenStatus{}, err
+    }
+    return tokenStatus{ID: token.ID, Status: token.Status, ExpiresOn: token.ExpiresOn}, nil
+}
+
+func fromCloudflare(record cloudflare.DNSRecord) dnsRecord {
+    return dnsRecord{
+        ID:       record.ID,
+        Type:     record.Type,
+        Name:     record.Name,
+        Content:  record.Content,
+        Data:     record.Data,
+        Priority: record.Priority,
+        TTL:      record.TTL,
+        Proxied:  record.Proxied != nil && *record.Proxied,
+        Comment:  record.Comment,
+        Tags:     record.Tags,
+    }
+}
+
+// Cloudflare error codes for a zone ID that does not exist or is malformed:
+// "Invalid zone identifier", "No route for that URI" and "Could not route to
+// ..., perhaps your object identifier is invalid?".
+var zoneNotFoundCodes = []int{1001, 7000, 7003}
+
+// recordNotFoundCode is Cloudflare's "Record does not exist." error.
+const recordNotFoundCode = 81044
+
+// cloudflareNotFound marks err as a notFoundError when it says the zone or
+// the record does not exist. The error code tells the two apart; a bare 404
+// is about the record for a request naming one, else about the zone.
+func cloudflareNotFound(err error, recordRequest bool) error {
+    var cfErr *cloudflare.Error
+    if !errors.As(err, &cfErr) {
+        return err
+    }
+    for _, code := range zoneNotFoundCodes {
+        if cfErr.InternalErrorCodeIs(code) {
+            return &notFoundError{zone: true, err: err}
+        }
+    }
+    if cfErr.InternalErrorCodeIs(recordNotFoundCode) {
+        return &notFoundError{err: err}
+    }
+    if cfErr.StatusCode == http.StatusNotFound {
+        return &notFoundError{zone: !recordRequest, err: err}
+    }
+    return err
+}
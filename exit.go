@@ -0,0 +1,49 @@
+package main
+
+import "errors"
+
+// Exit codes, so cron jobs and monitoring can tell a real failure from a
+// run that had nothing to do. Both "updated" and "already current" exit 0.
+const (
+    exitOK      = 0
+    exitConfig  = 1
+    exitNetwork = 2
+    exitAPI     = 3
+)
+
+// exitError tags err with the exit code the process should end with.
+type exitError struct {
+    code int
+    err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+func configError(err error) error  { return tagError(exitConfig, err) }
+func networkError(err error) error { return tagError(exitNetwork, err) }
+func apiError(err error) error     { return tagError(exitAPI, err) }
+
+// tagError keeps a tag err already has, the code closest to the cause wins,
+// e.g. a wrong zone_id is a configuration error even though it surfaced as
+// a failed API call.
+func tagError(code int, err error) error {
+    var exitErr *exitError
+    if errors.As(err, &exitErr) {
+        return err
+    }
+    return &exitError{code: code, err: err}
+}
+
+// exitCode returns the exit code err was tagged with, exitConfig if it was
+// not tagged at all.
+func exitCode(err error) int {
+    if err == nil {
+        return exitOK
+    }
+    var exitErr *exitError
+    if errors.As(err, &exitErr) {
+        return exitErr.code
+    }
+    return exitConfig
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "reflect"
+    "regexp"
+    "sort"
+    "strings"
+)
+
+// envRef matches a ${VAR} reference. A $ in any other form, e.g. $VAR or a
+// template's {{$ip := .IP}}, is left alone.
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnvRefs replaces the ${VAR} references in s with the values lookup
+// returns for them.
+func expandEnvRefs(s string, lookup func(name string) string) string {
+    return envRef.ReplaceAllStringFunc(s, func(ref string) string {
+        return lookup(envRef.FindStringSubmatch(ref)[1])
+    })
+}
+
+// expandEnv replaces ${VAR} references in every string of c with the value of
+// the environment variable. Referring to an unset variable is an error rather
+// than silently becoming empty.
+func (c *CfgFile) expandEnv() error {
+    missing := make(map[string]bool)
+    walkStrings(reflect.ValueOf(c).Elem(), reflect.Value{}, func(s, _ reflect.Value) {
+        s.SetString(expandEnvRefs(s.String(), func(name string) string {
+            value, ok := os.LookupEnv(name)
+            if !ok {
+                missing[name] = true
+            }
+            return value
+        }))
+    })
+    if len(missing) == 0 {
+        return nil
+    }
+
+    names := make([]string, 0, len(missing))
+    for name := range missing {
+        names = append(names, "${"+name+"}")
+    }
+    sort.Strings(names)
+    return fmt.Errorf("config refers to unset environment variables: %s", strings.Join(names, ", "))
+}
+
+// restoreEnvRefs puts the ${VAR} references of raw, the config as it was
+// read, back into c, so saving the config never writes the expanded values.
+// Values gddns has changed since loading are kept.
+func (c *CfgFile) restoreEnvRefs(raw *CfgFile) {
+    walkStrings(reflect.ValueOf(c).Elem(), reflect.ValueOf(raw).Elem(), func(s, ref reflect.Value) {
+        if !ref.IsValid() || !envRef.MatchString(ref.String()) {
+            return
+        }
+        if expandEnvRefs(ref.String(), os.Getenv) == s.String() {
+            s.SetString(ref.String())
+        }
+    })
+}
+
+// walkStrings calls fn for every string reachable from v, passing the string
+// at the same position in ref when ref is valid. Map values are copied,
+// modified and stored back since they are not addressable.
+func walkStrings(v, ref reflect.Value, fn func(s, ref reflect.Value)) {
+    switch v.Kind() {
+    case reflect.String:
+        fn(v, ref)
+    case reflect.Pointer:
+        if v.IsNil() {
+            return
+        }
+        if ref.IsValid() {
+            if ref.IsNil() {
+                return
+            }
+            ref = ref.Elem()
+        }
+        walkStrings(v.Elem(), ref, fn)
+    case reflect.Struct:
+        for i := 0; i < v.NumField(); i++ {
+            if !v.Type().Field(i).IsExported() {
+                continue
+            }
+            var fieldRef reflect.Value
+            if ref.IsValid() {
+                fieldRef = ref.Field(i)
+            }
+            walkStrings(v.Field(i), fieldRef, fn)
+        }
+    case reflect.Slice:
+        for i := 0; i < v.Len(); i++ {
+            var elemRef reflect.Value
+            if ref.IsValid() {
+                if i >= ref.Len() {
+                    return
+                }
+                elemRef = ref.Index(i)
+            }
+            walkStrings(v.Index(i), elemRef, fn)
+        }
+    case reflect.Map:
+        for _, key := range v.MapKeys() {
+            var elemRef reflect.Value
+            if ref.IsValid() {
+                if elemRef = ref.MapIndex(key); !elemRef.IsValid() {
+                    continue
+                }
+            }
+            elem := reflect.New(v.Type().Elem()).Elem()
+            elem.Set(v.MapIndex(key))
+            walkStrings(elem, elemRef, fn)
+            v.SetMapIndex(key, elem)
+        }
+    }
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "github.com/BurntSushi/toml"
+    "path/filepath"
+    "sigs.k8s.io/yaml"
+    "strings"
+)
+
+// configFormat returns the format of a config file from its extension,
+// "yaml", "toml" or "json". Anything unknown is read as JSON.
+func configFormat(filename string) string {
+    switch strings.ToLower(filepath.Ext(filename)) {
+    case ".yaml", ".yml":
+        return "yaml"
+    case ".toml":
+        return "toml"
+    }
+    return "json"
+}
+
+// configToJSON converts the contents of a YAML or TOML config file to JSON,
+// so every format decodes into CfgFile through its json tags.
+func configToJSON(filename string, data []byte) ([]byte, error) {
+    switch configFormat(filename) {
+    case "yaml":
+        return yaml.YAMLToJSON(data)
+    case "toml":
+        var doc map[string]interface{}
+        if err := toml.Unmarshal(data, &doc); err != nil {
+            return nil, err
+        }
+        return json.Marshal(doc)
+    }
+    return data, nil
+}
+
+// configFromJSON converts a JSON encoded config back to the format of
+// filename.
+func configFromJSON(filename string, data []byte) ([]byte, error) {
+    switch configFormat(filename) {
+    case "yaml":
+        return yaml.JSONToYAML(data)
+    case "toml":
+        dec := json.NewDecoder(bytes.NewReader(data))
+        dec.UseNumber()
+        var doc map[string]interface{}
+        if err := dec.Decode(&doc); err != nil {
+            return nil, err
+        }
+
+        var out bytes.Buffer
+        enc := toml.NewEncoder(&out)
+        enc.Indent = ""
+        if err := enc.Encode(tomlValue(doc)); err != nil {
+            return nil, fmt.Errorf("encoding TOML: %w", err)
+        }
+        return out.Bytes(), nil
+    }
+    return data, nil
+}
+
+// tomlValue prepares decoded JSON for the TOML encoder: numbers become
+// integers where they are whole, so a ttl stays 300 rather than 300.0, and
+// nulls, which TOML cannot express, are dropped.
+func tomlValue(v interface{}) interface{} {
+    switch v := v.(type) {
+    case map[string]interface{}:
+        for key, value := range v {
+            if value == nil {
+                delete(v, key)
+                continue
+            }
+            v[key] = tomlValue(value)
+        }
+    case []interface{}:
+        for i, value := range v {
+            v[i] = tomlValue(value)
+        }
+    case json.Number:
+        if n, err := v.Int64(); err == nil {
+            return n
+        }
+        f, _ := v.Float64()
+        return f
+    }
+    return v
+}
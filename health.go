@@ -0,0 +1,74 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "sync"
+    "time"
+)
+
+// healthState tracks the outcome of the daemon's update cycles for /healthz.
+type healthState struct {
+    mu          sync.Mutex
+    lastSuccess time.Time
+    lastIP      map[string]string
+    lastError   string
+}
+
+var health = &healthState{}
+
+type healthStatus struct {
+    Healthy     bool              `json:"healthy"`
+    LastSuccess *time.Time        `json:"last_success"`
+    LastIP      map[string]string `json:"last_ip"`
+    LastError   string            `json:"last_error,omitempty"`
+}
+
+// succeeded records a cycle in which every record was checked without error.
+func (h *healthState) succeeded(config *Config) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+
+    h.lastSuccess = time.Now()
+    h.lastIP = make(map[string]string)
+    for _, recordType := range config.recordTypes() {
+        if ip := config.ip(recordType); ip != "" {
+            h.lastIP[recordType] = ip
+        }
+    }
+    h.lastError = ""
+}
+
+func (h *healthState) failed(err error) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    h.lastError = err.Error()
+}
+
+// status reports healthy when the last successful cycle is at most two
+// intervals old, so a single missed tick does not restart the daemon.
+func (h *healthState) status(interval time.Duration) healthStatus {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+
+    status := healthStatus{LastIP: h.lastIP, LastError: h.lastError}
+    if !h.lastSuccess.IsZero() {
+        lastSuccess := h.lastSuccess
+        status.LastSuccess = &lastSuccess
+        status.Healthy = time.Since(lastSuccess) <= 2*interval
+    }
+    return status
+}
+
+// healthHandler serves the health status as JSON, with a 503 when unhealthy.
+func healthHandler(interval time.Duration) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        status := health.status(interval)
+
+        w.Header().Set("Content-Type", "application/json")
+        if !status.Healthy {
+            w.WriteHeader(http.StatusServiceUnavailable)
+        }
+        json.NewEncoder(w).Encode(status)
+    })
+}
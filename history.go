@@ -0,0 +1,49 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "time"
+)
+
+// ipHistoryPath resolves ip_history_file, relative paths being relative to
+// the config file.
+func (c *Config) ipHistoryPath() string {
+    return c.relativePath(c.IPHistoryFile)
+}
+
+// relativePath resolves a path from the config, which is relative to the
+// config file unless it is absolute.
+func (c *Config) relativePath(path string) string {
+    if path == "" || filepath.IsAbs(path) {
+        return path
+    }
+    return filepath.Join(filepath.Dir(c.file()), path)
+}
+
+// appendIPHistory adds a line to ip_history_file for every address change,
+// e.g. "2024-05-01T10:00:00Z home.example.com A 1.2.3.4 -> 5.6.7.8". An
+// empty old address means the record was created. Failing to write the
+// history never fails the update.
+func appendIPHistory(config *Config, rec *Record, recordType, oldIP, newIP string) {
+    path := config.ipHistoryPath()
+    if path == "" || dryRun {
+        return
+    }
+    if oldIP == "" {
+        oldIP = "-"
+    }
+
+    file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+    if err != nil {
+        logError("error opening IP history file", err, "path", path)
+        return
+    }
+    defer file.Close()
+
+    line := fmt.Sprintf("%s %s %s %s -> %s\n", time.Now().UTC().Format(time.RFC3339), rec.fqdn(), recordType, oldIP, newIP)
+    if _, err := file.WriteString(line); err != nil {
+        logError("error writing IP history file", err, "path", path)
+    }
+}
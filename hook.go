@@ -0,0 +1,50 @@
+package main
+
+import (
+    "context"
+    "log/slog"
+    "os"
+    "os/exec"
+    "strings"
+    "time"
+)
+
+// runChangeCommand runs on_change_command after recordType of rec moved from
+// oldIP to newIP, e.g. to restart a service or update a firewall. The new
+// address is appended to its arguments and both addresses are in the
+// environment as GDDNS_NEW_IP and GDDNS_OLD_IP. Like the webhook it is best
+// effort: a failing command is logged with its output and never fails the
+// update.
+func runChangeCommand(ctx context.Context, config *Config, rec *Record, recordType, oldIP, newIP string) {
+    if len(config.OnChangeCommand) == 0 || dryRun {
+        return
+    }
+
+    ctx, cancel := context.WithTimeout(ctx, config.requestTimeout())
+    defer cancel()
+
+    args := append(append([]string{}, config.OnChangeCommand[1:]...), newIP)
+    cmd := exec.CommandContext(ctx, config.OnChangeCommand[0], args...)
+    cmd.Env = append(os.Environ(),
+        "GDDNS_NEW_IP="+newIP,
+        "GDDNS_OLD_IP="+oldIP,
+        "GDDNS_RECORD="+rec.fqdn(),
+        "GDDNS_TYPE="+recordType,
+    )
+    // A child the command left running may hold on to the output pipe
+    cmd.WaitDelay = time.Second
+
+    start := time.Now()
+    output, err := cmd.CombinedOutput()
+    attrs := []any{"record", rec.CNAME, "type", recordType, "command", config.OnChangeCommand[0],
+        "output", strings.TrimSpace(string(output)), "duration_ms", durationMS(start)}
+    if ctx.Err() == context.DeadlineExceeded {
+        slog.Warn("on_change_command timed out", append(attrs, "timeout", config.requestTimeout())...)
+        return
+    }
+    if err != nil {
+        slog.Warn("on_change_command failed", append(attrs, "error", err)...)
+        return
+    }
+    logEvent("hook_ran", "on_change_command ran", attrs...)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "errors"
+    "fmt"
+    "os"
+    "strconv"
+    "strings"
+)
+
+// initCommand asks for the basic record settings and writes a new config file.
+// With API credentials in the environment it picks the zone and finds the
+// existing records to adopt through the API. Every answer can be given as a
+// flag instead, so it also runs unattended.
+func initCommand(args []string) error {
+    fs := newFlagSet("init")
+    force := fs.Bool("force", false, "overwrite an existing config file")
+    domain := fs.String("domain", "", "the domain, e.g. example.com")
+    cname := fs.String("cname", "", "the record name, e.g. home, @ for the domain itself or * for a wildcard")
+    zoneID := fs.String("zone-id", "", "the zone ID, looked up from the domain when left out")
+    recordType := fs.String("type", "", "the record type, A, AAAA or both")
+    adopt := fs.Bool("adopt", false, "adopt existing records with the same name without asking")
+    offline := fs.Bool("offline", false, "do not use the Cloudflare API to look up the zone and existing records")
+    if err := start(fs, args); err != nil {
+        return err
+    }
+
+    if configFile == stdinConfig {
+        return configError(errors.New("gddns init asks its questions on stdin, --config - cannot be used with it"))
+    }
+    if _, err := os.Stat(configPath()); err == nil && !*force {
+        return configError(fmt.Errorf("refusing to overwrite config, %s already exists, use --force to replace it", configPath()))
+    }
+
+    in := bufio.NewReader(os.Stdin)
+    rec := &Record{
+        Domain: ask(in, *domain, "Domain (e.g. example.com)", ""),
+        CNAME:  ask(in, *cname, "Record name (e.g. home, @ for the domain itself or * for a wildcard)", ""),
+        Type:   ask(in, *recordType, "Record type (A, AAAA or both)", "A"),
+        ZoneID: *zoneID,
+    }
+    config := &Config{CfgFile: &CfgFile{Records: []*Record{rec}}}
+
+    api, err := initClient(config, rec, *offline)
+    if err != nil {
+        return err
+    }
+    ctx, stop := signalContext()
+    defer stop()
+
+    if rec.ZoneID == "" && api != nil {
+        if rec.ZoneID, err = pickZone(ctx, api, config, in, rec.Domain); err != nil {
+            return err
+        }
+    }
+    if rec.ZoneID == "" {
+        rec.ZoneID = prompt(in, "Zone ID (leave blank to discover it from the domain)", "")
+    }
+
+    if api != nil && rec.ZoneID != "" {
+        for _, recordType := range rec.recordTypes() {
+            if err := pickExistingRecord(ctx, api, config, in, rec, recordType, *adopt); err != nil {
+                return err
+            }
+        }
+    }
+
+    if err := config.Validate(); err != nil {
+        return configError(err)
+    }
+    if err := saveConfig(config); err != nil {
+        return fmt.Errorf("saving config: %w", err)
+    }
+    fmt.Printf("Wrote %s\n", configPath())
+    return nil
+}
+
+// initClient returns the provider init looks things up with, or nil
+// when it works offline because --offline is set or no credentials are.
+func initClient(config *Config, rec *Record, offline bool) (DNSProvider, error) {
+    if offline {
+        return nil, nil
+    }
+    if err := config.loadEnv(); err != nil {
+        return nil, configError(err)
+    }
+    if !config.hasEnvCredentials() {
+        fmt.Println("No API credentials in the environment, not looking up the zone and existing records.")
+        return nil, nil
+    }
+    return newClientSet(config).forRecord(rec)
+}
+
+// pickZone returns the ID of the zone named domain, asking which one is meant
+// when the credentials see several.
+func pickZone(ctx context.Context, api DNSProvider, config *Config, in *bufio.Reader, domain string) (string, error) {
+    var zones []dnsZone
+    err := withRetry(ctx, config, "list zones", func(ctx context.Context) error {
+        var err error
+        zones, err = api.FindZones(ctx, domain)
+        return err
+    })
+    if err != nil {
+        return "", apiError(fmt.Errorf("looking up zone %s: %w", domain, err))
+    }
+
+    switch len(zones) {
+    case 0:
+        fmt.Printf("No zone named %s is visible to these credentials.\n", domain)
+        return "", nil
+    case 1:
+        fmt.Printf("Using zone %s (%s, account %s)\n", zones[0].Name, zones[0].ID, zones[0].Account)
+        return zones[0].ID, nil
+    }
+
+    fmt.Printf("There are %d zones named %s:\n", len(zones), domain)
+    for i, zone := range zones {
+        fmt.Printf("  %d) %s (account %s)\n", i+1, zone.ID, zone.Account)
+    }
+    i, err := choose(in, "Zone", len(zones))
+    if err != nil {
+        return "", configError(err)
+    }
+    return zones[i].ID, nil
+}
+
+// pickExistingRecord looks for recordType records that already have the name
+// of rec and offers to adopt one, so gddns takes it over instead of creating a
+// second one.
+func pickExistingRecord(ctx context.Context, api DNSProvider, config *Config, in *bufio.Reader, rec *Record, recordType string, adopt bool) error {
+    records, err := findRecord(ctx, api, config, rec, recordType)
+    if err != nil {
+        return fmt.Errorf("looking for existing %s records: %w", recordType, apiError(err))
+    }
+
+    switch {
+    case len(records) == 0:
+        fmt.Printf("No %s record named %s yet, the first update creates it.\n", recordType, rec.fqdn())
+        return nil
+    case len(records) == 1:
+        record := records[0]
+        fmt.Printf("Found %s record %s -> %s (%s)\n", recordType, record.Name, record.Content, record.ID)
+        if adopt || strings.HasPrefix(strings.ToLower(prompt(in, "Adopt it", "Y")), "y") {
+            rec.setRecordID(recordType, record.ID)
+        }
+        return nil
+    }
+
+    fmt.Printf("Found %d %s records named %s:\n", len(records), recordType, rec.fqdn())
+    for i, record := range records {
+        fmt.Printf("  %d) %s -> %s\n", i+1, record.ID, record.Content)
+    }
+    i, err := choose(in, "Record to adopt", len(records))
+    if err != nil {
+        return configError(err)
+    }
+    rec.setRecordID(recordType, records[i].ID)
+    return nil
+}
+
+// choose asks for a number within 1-n and returns it as an index.
+func choose(in *bufio.Reader, question string, n int) (int, error) {
+    answer := prompt(in, fmt.Sprintf("%s (1-%d)", question, n), "")
+    i, err := strconv.Atoi(answer)
+    if err != nil || i < 1 || i > n {
+        return 0, fmt.Errorf("invalid choice %q, expected a number within 1-%d", answer, n)
+    }
+    return i - 1, nil
+}
+
+// ask returns value when it was given as a flag and prompts for it otherwise.
+func ask(in *bufio.Reader, value, question, fallback string) string {
+    if value != "" {
+        return value
+    }
+    return prompt(in, question, fallback)
+}
+
+// prompt reads one line from in, returning fallback for an empty answer.
+func prompt(in *bufio.Reader, question, fallback string) string {
+    if fallback != "" {
+        fmt.Printf("%s [%s]: ", question, fallback)
+    } else {
+        fmt.Printf("%s: ", question)
+    }
+
+    answer, _ := in.ReadString('\n')
+    answer = strings.TrimSpace(answer)
+    if answer == "" {
+        return fallback
+    }
+    return answer
+}
@@ -0,0 +1,480 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "log/slog"
+    "net"
+    "net/http"
+    "net/url"
+    "strings"
+    "sync"
+    "time"
+)
+
+// IPProvider looks up the public address of this host.
+type IPProvider interface {
+    PublicIP(ctx context.Context) (string, error)
+}
+
+var defaultIPProviders = []string{"ipify", "icanhazip", "ifconfig.me"}
+
+// ipProviderEndpoints maps provider names to their IPv4 and IPv6 endpoints.
+var ipProviderEndpoints = map[string]struct{ v4, v6 string }{
+    "ipify":       {"https://api.ipify.org?format=text", "https://api6.ipify.org?format=text"},
+    "icanhazip":   {"https://ipv4.icanhazip.com", "https://ipv6.icanhazip.com"},
+    "ifconfig.me": {"https://ifconfig.me/ip", "https://ifconfig.me/ip"},
+}
+
+// httpIPProvider fetches the address as plain text from a "what is my IP"
+// service.
+type httpIPProvider struct {
+    name     string
+    endpoint string
+    client   *http.Client
+}
+
+func (p *httpIPProvider) PublicIP(ctx context.Context) (string, error) {
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.endpoint, nil)
+    if err != nil {
+        return "", fmt.Errorf("%s: %w", p.name, err)
+    }
+
+    resp, err := p.client.Do(req)
+    if err != nil {
+        return "", fmt.Errorf("%s: %w", p.name, err)
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return "", fmt.Errorf("%s: unexpected status %s", p.name, resp.Status)
+    }
+    // An address is never more than a few dozen bytes, anything longer is
+    // an error page.
+    body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+    if err != nil {
+        return "", fmt.Errorf("%s: %w", p.name, err)
+    }
+
+    slog.Debug("IP provider response", "provider", p.name, "endpoint", p.endpoint, "status", resp.StatusCode, "body", string(body))
+    ip := strings.TrimSpace(string(body))
+    if net.ParseIP(ip) == nil {
+        return "", fmt.Errorf("%s: response %q is not a valid IP address", p.name, truncate(ip, 64))
+    }
+    return ip, nil
+}
+
+// truncate shortens s to at most n bytes for use in error messages.
+func truncate(s string, n int) string {
+    if len(s) <= n {
+        return s
+    }
+    return s[:n] + "..."
+}
+
+// ipResolver tries each provider in order until one returns a valid address
+// of the wanted family.
+type ipResolver struct {
+    recordType string
+    providers  []*httpIPProvider
+}
+
+// ipEndpoint returns the URL to query for an ip_providers entry, which is
+// either a known provider name or an http(s) URL returning the address as
+// plain text.
+func ipEndpoint(name, recordType string) (string, bool) {
+    if u, err := url.Parse(name); err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+        return name, true
+    }
+
+    endpoints, ok := ipProviderEndpoints[name]
+    if !ok {
+        return "", false
+    }
+    if recordType == "AAAA" {
+        return endpoints.v6, true
+    }
+    return endpoints.v4, true
+}
+
+// newIPResolver builds a resolver over the named providers, all sharing client.
+func newIPResolver(names []string, recordType string, client *http.Client) (*ipResolver, error) {
+    if len(names) == 0 {
+        names = defaultIPProviders
+    }
+
+    resolver := &ipResolver{recordType: recordType}
+    for _, name := range names {
+        endpoint, ok := ipEndpoint(name, recordType)
+        if !ok {
+            return nil, fmt.Errorf("unknown IP provider %q", name)
+        }
+        resolver.providers = append(resolver.providers, &httpIPProvider{name: name, endpoint: endpoint, client: client})
+    }
+
+    return resolver, nil
+}
+
+func (r *ipResolver) PublicIP(ctx context.Context) (string, error) {
+    var failures []string
+    for _, provider := range r.providers {
+        start := time.Now()
+        ip, err := provider.PublicIP(ctx)
+        if err == nil {
+            err = checkAddressFamily(r.recordType, ip)
+        }
+        observeIPProvider(provider.name, start, err)
+        if err != nil {
+            failures = append(failures, err.Error())
+            continue
+        }
+
+        return ip, nil
+    }
+
+    return "", fmt.Errorf("no IP provider returned a usable address: %s", strings.Join(failures, "; "))
+}
+
+// interfaceIPProvider reads the address straight off a local network
+// interface, for records that should point at a LAN address.
+type interfaceIPProvider struct {
+    name       string
+    recordType string
+
+    // suffix, when set, replaces the host part of an IPv6 address, keeping
+    // only the prefix delegated to the interface.
+    suffix net.IP
+}
+
+func (p *interfaceIPProvider) PublicIP(ctx context.Context) (string, error) {
+    iface, err := net.InterfaceByName(p.name)
+    if err != nil {
+        return "", fmt.Errorf("interface %s: %w", p.name, err)
+    }
+    addrs, err := iface.Addrs()
+    if err != nil {
+        return "", fmt.Errorf("interface %s: %w", p.name, err)
+    }
+
+    for _, addr := range addrs {
+        ipNet, ok := addr.(*net.IPNet)
+        if !ok || !ipNet.IP.IsGlobalUnicast() {
+            continue
+        }
+        if checkAddressFamily(p.recordType, ipNet.IP.String()) != nil {
+            continue
+        }
+        if p.recordType == "AAAA" && p.suffix != nil {
+            return withSuffix(ipNet, p.suffix)
+        }
+        return ipNet.IP.String(), nil
+    }
+
+    return "", fmt.Errorf("interface %s has no global unicast address usable for an %s record", p.name, p.recordType)
+}
+
+// withSuffix combines the prefix of network with the host part suffix, e.g.
+// 2001:db8:1:2::/64 and ::1234 become 2001:db8:1:2::1234.
+func withSuffix(network *net.IPNet, suffix net.IP) (string, error) {
+    ones, _ := network.Mask.Size()
+    ip := make(net.IP, net.IPv6len)
+    for i := range ip {
+        if suffix[i]&network.Mask[i] != 0 {
+            return "", fmt.Errorf("ipv6_suffix %s does not fit in the host part of %s, the prefix is /%d", suffix, network, ones)
+        }
+        ip[i] = network.IP[i]&network.Mask[i] | suffix[i]
+    }
+    if !ip.IsGlobalUnicast() {
+        return "", fmt.Errorf("%s, the prefix of %s with ipv6_suffix %s, is not a global unicast address", ip, network, suffix)
+    }
+    return ip.String(), nil
+}
+
+// interfaceName returns the interface of an "interface:<name>" ip_source.
+func interfaceName(ipSource string) (string, bool) {
+    return strings.CutPrefix(ipSource, "interface:")
+}
+
+// newIPProvider returns the address source selected by ip_source.
+func newIPProvider(config *Config, recordType string) (IPProvider, error) {
+    if name, ok := interfaceName(config.IPSource); ok {
+        return &interfaceIPProvider{name: name, recordType: recordType, suffix: net.ParseIP(config.IPv6Suffix)}, nil
+    }
+    resolver, err := newIPResolver(config.IPProviders, recordType, newHTTPClient(config, config.requestTimeout()))
+    if err != nil || config.IPSource != "upnp" || recordType != "A" {
+        // The gateway has no IPv6 WAN address to offer, AAAA always uses
+        // the public providers
+        return resolver, err
+    }
+
+    upnp := &upnpIPProvider{allowPrivate: config.AllowPrivateIP}
+    if config.IPSourceStrict {
+        return upnp, nil
+    }
+    return &fallbackIPProvider{primary: upnp, fallback: resolver}, nil
+}
+
+// ipCache remembers the last lookup per config and address family, so
+// repeated lookups within ip_cache_ttl do not hit the providers again. The
+// configs of --config-dir may use different sources, e.g. one a LAN
+// interface, and never see each other's addresses.
+var ipCache = struct {
+    sync.Mutex
+    entries map[ipCacheKey]cachedIP
+}{entries: make(map[ipCacheKey]cachedIP)}
+
+type ipCacheKey struct {
+    config     string
+    ipSource   string
+    recordType string
+}
+
+func newIPCacheKey(config *Config, recordType string) ipCacheKey {
+    return ipCacheKey{config: config.file(), ipSource: config.IPSource, recordType: recordType}
+}
+
+type cachedIP struct {
+    ip      string
+    fetched time.Time
+}
+
+// ipCacheTTL defaults to half the poll interval, or the shortest gap of a
+// cron schedule, so the daemon still does a fresh lookup on every tick.
+func (c *Config) ipCacheTTL() time.Duration {
+    if c.IPCacheTTL != "" {
+        if ttl, err := time.ParseDuration(c.IPCacheTTL); err == nil {
+            return ttl
+        }
+    }
+    interval, err := pollInterval(c)
+    if err != nil {
+        interval = defaultInterval
+    }
+    if sched, _, err := daemonSchedule(c); err == nil {
+        if cron, ok := sched.(*cronSchedule); ok {
+            interval, _ = cron.gaps()
+        }
+    }
+    return interval / 2
+}
+
+func cachedPublicIP(config *Config, recordType string) (string, bool) {
+    ipCache.Lock()
+    defer ipCache.Unlock()
+
+    entry, ok := ipCache.entries[newIPCacheKey(config, recordType)]
+    if !ok || time.Since(entry.fetched) >= config.ipCacheTTL() {
+        return "", false
+    }
+    return entry.ip, true
+}
+
+func cachePublicIP(config *Config, recordType, ip string) {
+    ipCache.Lock()
+    defer ipCache.Unlock()
+    ipCache.entries[newIPCacheKey(config, recordType)] = cachedIP{ip: ip, fetched: time.Now()}
+}
+
+func getPublicIP(ctx context.Context, config *Config, recordType string) (string, error) {
+    // The checks run on a cached address too, the config may have been
+    // reloaded with other lists since
+    if ip, ok := cachedPublicIP(config, recordType); ok {
+        slog.Debug("using cached public IP", "type", recordType, "ip", ip)
+        if err := checkFetchedIP(config, ip); err != nil {
+            return "", err
+        }
+        return ip, nil
+    }
+
+    provider, err := newIPProvider(config, recordType)
+    if err != nil {
+        return "", err
+    }
+
+    start := time.Now()
+    ipChecksTotal.Inc()
+    var ip string
+    err = withRetry(ctx, config, "public IP lookup", func(ctx context.Context) error {
+        var err error
+        ip, err = provider.PublicIP(ctx)
+        return err
+    })
+    if err != nil {
+        return "", err
+    }
+    if err := checkFetchedIP(config, ip); err != nil {
+        return "", err
+    }
+    cachePublicIP(config, recordType, ip)
+    setCurrentIPMetric(recordType, ip)
+    logEvent("ip_fetched", "public IP fetched", "type", recordType, "ip", ip, "duration_ms", durationMS(start))
+
+    return ip, nil
+}
+
+// ipFetchDeadline bounds a fetchIPs call, so an address family that never
+// answers, typically IPv6 on a host without it, cannot hold up the other.
+const ipFetchDeadline = time.Minute
+
+// fetchIPs looks up the public address of every family in families at the
+// same time and returns the ones found, keyed by record type, next to the
+// errors of the others.
+func fetchIPs(ctx context.Context, config *Config, families []string) (map[string]string, map[string]error) {
+    ctx, cancel := context.WithTimeout(ctx, ipFetchDeadline)
+    defer cancel()
+
+    var mu sync.Mutex
+    var wg sync.WaitGroup
+    ips := make(map[string]string)
+    errs := make(map[string]error)
+    for _, recordType := range families {
+        recordType := recordType
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            ip, err := getPublicIP(ctx, config, recordType)
+
+            mu.Lock()
+            defer mu.Unlock()
+            if err != nil {
+                errs[recordType] = err
+                return
+            }
+            ips[recordType] = ip
+        }()
+    }
+    wg.Wait()
+    return ips, errs
+}
+
+// fetchPublicIPs looks up the current public IP for every managed record type
+// that was not already supplied with --ip. A family that only "both" records
+// use may be missing, e.g. on a host without IPv6 connectivity, unless
+// require_both is set.
+func fetchPublicIPs(ctx context.Context, config *Config) error {
+    var families []string
+    for _, recordType := range config.recordTypes() {
+        if config.ip(recordType) == "" {
+            families = append(families, recordType)
+        }
+    }
+
+    ips, errs := fetchIPs(ctx, config, families)
+    for _, recordType := range families {
+        ip, err := ips[recordType], errs[recordType]
+        if errors.Is(err, errIPRefused) {
+            slog.Warn("public address refused, skipping these records", "type", recordType, "error", err)
+            continue
+        }
+        if err != nil {
+            if !config.familyOptional(recordType) {
+                return fmt.Errorf("%s record: %w", recordType, err)
+            }
+            slog.Warn("no usable public address, skipping these records", "type", recordType, "error", err)
+            continue
+        }
+        config.setIP(recordType, ip)
+    }
+    return nil
+}
+
+// useSuppliedIPs sets the public addresses from --ip or --ip-stdin, at most
+// one per address family.
+func useSuppliedIPs(config *Config, ips []string) error {
+    for _, ip := range ips {
+        parsed := net.ParseIP(ip)
+        if parsed == nil {
+            return fmt.Errorf("%q is not a valid IP address", ip)
+        }
+        recordType := "AAAA"
+        if parsed.To4() != nil {
+            recordType = "A"
+        }
+        if config.ip(recordType) != "" {
+            return fmt.Errorf("more than one %s address supplied", recordType)
+        }
+        if err := checkPublishable(config, ip); err != nil {
+            return err
+        }
+
+        config.setIP(recordType, parsed.String())
+        slog.Info("using supplied IP", "type", recordType, "ip", parsed.String())
+    }
+    return nil
+}
+
+// checkAddressFamily makes sure we never push an IPv4 literal into an AAAA
+// record or vice versa.
+func checkAddressFamily(recordType, ip string) error {
+    parsed := net.ParseIP(ip)
+    if parsed == nil {
+        return fmt.Errorf("%q is not a valid IP address", ip)
+    }
+
+    isV4 := parsed.To4() != nil
+    if recordType == "A" && !isV4 {
+        return fmt.Errorf("%s is not an IPv4 address, cannot use it for an A record", ip)
+    }
+    if recordType == "AAAA" && isV4 {
+        return fmt.Errorf("%s is not an IPv6 address, cannot use it for an AAAA record", ip)
+    }
+
+    return nil
+}
+
+// cgnatRange is the shared address space carriers use for CGNAT, RFC 6598.
+var cgnatRange = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// isPrivateIP reports whether ip is an RFC 1918, CGNAT or unique local
+// address. Getting one back usually means the host is behind CGNAT and has
+// no public address of its own.
+func isPrivateIP(ip string) bool {
+    parsed := net.ParseIP(ip)
+    return parsed != nil && (parsed.IsPrivate() || cgnatRange.Contains(parsed))
+}
+
+// errIPRefused marks an address ip_denylist or ip_allowlist rules out. Its
+// records are skipped rather than failed, the lists are there to expect it.
+var errIPRefused = errors.New("refusing to publish it")
+
+// checkFetchedIP runs the checks of checkNotPrivate and checkPublishable on
+// an address the ip_source looked up.
+func checkFetchedIP(config *Config, ip string) error {
+    if err := checkNotPrivate(config, ip); err != nil {
+        return err
+    }
+    return checkPublishable(config, ip)
+}
+
+// checkNotPrivate refuses a private address from the public IP providers or
+// the gateway unless allow_private_ip is set. An interface: source is there
+// to publish LAN addresses, and an address given with --ip is taken as meant.
+func checkNotPrivate(config *Config, ip string) error {
+    if _, ok := interfaceName(config.IPSource); ok || config.AllowPrivateIP || !isPrivateIP(ip) {
+        return nil
+    }
+    return fmt.Errorf("%s is a private address, refusing to publish it (set allow_private_ip for LAN setups)", ip)
+}
+
+// checkPublishable refuses an address gddns must not publish: one within
+// ip_denylist, or one outside ip_allowlist when that is set. A backup link or
+// VPN handing out a junk address then leaves the records alone instead of
+// breaking them.
+func checkPublishable(config *Config, ip string) error {
+    parsed := net.ParseIP(ip)
+    for _, cidr := range config.IPDenylist {
+        if _, network, err := net.ParseCIDR(cidr); err == nil && network.Contains(parsed) {
+            return fmt.Errorf("%s is within %s of ip_denylist, %w", ip, cidr, errIPRefused)
+        }
+    }
+    if len(config.IPAllowlist) == 0 {
+        return nil
+    }
+    for _, cidr := range config.IPAllowlist {
+        if _, network, err := net.ParseCIDR(cidr); err == nil && network.Contains(parsed) {
+            return nil
+        }
+    }
+    return fmt.Errorf("%s is not within any range of ip_allowlist, %w", ip, errIPRefused)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+// ipServer answers every request with status and body.
+func ipServer(t *testing.T, status int, body string) *httptest.Server {
+    t.Helper()
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(status)
+        w.Write([]byte(body))
+    }))
+    t.Cleanup(server.Close)
+    return server
+}
+
+func TestHTTPIPProvider(t *testing.T) {
+    tests := []struct {
+        name    string
+        status  int
+        body    string
+        want    string
+        wantErr string
+    }{
+        {name: "IPv4", status: http.StatusOK, body: "192.0.2.7", want: "192.0.2.7"},
+        {name: "IPv6", status: http.StatusOK, body: "2001:db8::7", want: "2001:db8::7"},
+        {name: "whitespace trimmed", status: http.StatusOK, body: "  192.0.2.7\r\n", want: "192.0.2.7"},
+        {name: "non-200", status: http.StatusServiceUnavailable, body: "192.0.2.7", wantErr: "unexpected status 503"},
+        {name: "not an IP", status: http.StatusOK, body: "<html>rate limited</html>", wantErr: "is not a valid IP address"},
+        {name: "empty body", status: http.StatusOK, body: "", wantErr: "is not a valid IP address"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            server := ipServer(t, tt.status, tt.body)
+            provider := &httpIPProvider{name: "test", endpoint: server.URL, client: server.Client()}
+
+            got, err := provider.PublicIP(context.Background())
+            if tt.wantErr != "" {
+                if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                    t.Fatalf("PublicIP() error = %v, want %q", err, tt.wantErr)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("PublicIP() error = %v", err)
+            }
+            if got != tt.want {
+                t.Errorf("PublicIP() = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestIPResolver(t *testing.T) {
+    good := ipServer(t, http.StatusOK, "192.0.2.7\n")
+    down := ipServer(t, http.StatusInternalServerError, "")
+    garbage := ipServer(t, http.StatusOK, "not an address")
+    v6 := ipServer(t, http.StatusOK, "2001:db8::7")
+
+    tests := []struct {
+        name       string
+        providers  []string
+        recordType string
+        want       string
+        wantErr    bool
+    }{
+        {name: "first provider", providers: []string{good.URL, down.URL}, recordType: "A", want: "192.0.2.7"},
+        {name: "falls back", providers: []string{down.URL, garbage.URL, good.URL}, recordType: "A", want: "192.0.2.7"},
+        {name: "wrong family skipped", providers: []string{v6.URL, good.URL}, recordType: "A", want: "192.0.2.7"},
+        {name: "IPv6", providers: []string{good.URL, v6.URL}, recordType: "AAAA", want: "2001:db8::7"},
+        {name: "all fail", providers: []string{down.URL, garbage.URL}, recordType: "A", wantErr: true},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            resolver, err := newIPResolver(tt.providers, tt.recordType, http.DefaultClient)
+            if err != nil {
+                t.Fatalf("newIPResolver() error = %v", err)
+            }
+
+            got, err := resolver.PublicIP(context.Background())
+            if (err != nil) != tt.wantErr {
+                t.Fatalf("PublicIP() error = %v, want error %t", err, tt.wantErr)
+            }
+            if got != tt.want {
+                t.Errorf("PublicIP() = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestNewIPResolverUnknownProvider(t *testing.T) {
+    if _, err := newIPResolver([]string{"ipify", "nosuchprovider"}, "A", http.DefaultClient); err == nil {
+        t.Fatal("newIPResolver() accepted an unknown provider")
+    }
+}
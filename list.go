@@ -0,0 +1,168 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log/slog"
+    "os"
+    "sort"
+    "strings"
+    "text/tabwriter"
+)
+
+// listedRecord is one line of "gddns list".
+type listedRecord struct {
+    Name     string `json:"name"`
+    Type     string `json:"type"`
+    Content  string `json:"content"`
+    TTL      TTL    `json:"ttl"`
+    Proxied  bool   `json:"proxied"`
+    ID       string `json:"id"`
+    ZoneID   string `json:"zone_id"`
+    InConfig bool   `json:"in_config"`
+}
+
+// listCommand implements "gddns list", which prints every record in the
+// zones of the config that gddns created or took over, recognised by its
+// tags or its default comment. Records that are not in the config were left
+// behind by another config or an old run.
+func listCommand(args []string) error {
+    fs := newFlagSet("list")
+    asJSON := fs.Bool("json", false, "print the records as JSON instead of a table")
+    zoneID := fs.String("zone-id", "", "list this zone instead of the zones of the configured records")
+    if err := start(fs, args); err != nil {
+        return err
+    }
+
+    clients, config, err := setup()
+    if err != nil {
+        return err
+    }
+    ctx, stop := signalContext()
+    defer stop()
+
+    // The IDs in the config, to tell the records it manages from leftovers
+    inConfig := make(map[string]bool)
+    for _, rec := range config.Records {
+        ids := []string{rec.RecordID, rec.RecordIDv6, rec.SRVRecordID}
+        for _, static := range rec.Static {
+            ids = append(ids, static.ID)
+        }
+        for _, id := range ids {
+            if id != "" {
+                inConfig[id] = true
+            }
+        }
+    }
+
+    var listed []listedRecord
+    seen := make(map[string]bool)
+    for _, rec := range config.Records {
+        if config.provider(rec.Credentials) == providerDigitalOcean {
+            slog.Warn("DigitalOcean records have no tags or comments, not listing them", "record", rec.CNAME)
+            continue
+        }
+        api, err := clients.forRecord(rec)
+        if err != nil {
+            return err
+        }
+
+        zone := *zoneID
+        if zone == "" {
+            if rec.ZoneID == "" {
+                if err := resolveZoneID(ctx, api, config, rec); err != nil {
+                    return fmt.Errorf("discovering zone ID for %s: %w", rec.fqdn(), apiError(err))
+                }
+            }
+            zone = rec.ZoneID
+        }
+        if seen[zone] {
+            continue
+        }
+        seen[zone] = true
+
+        records, err := listManagedRecords(ctx, api, config, zone)
+        if err != nil {
+            return fmt.Errorf("listing records of zone %s: %w", zone, apiError(err))
+        }
+        for _, record := range records {
+            listed = append(listed, listedRecord{
+                Name:     record.Name,
+                Type:     record.Type,
+                Content:  record.Content,
+                TTL:      TTL(record.TTL),
+                Proxied:  record.Proxied,
+                ID:       record.ID,
+                ZoneID:   zone,
+                InConfig: inConfig[record.ID],
+            })
+        }
+    }
+    sort.SliceStable(listed, func(i, j int) bool {
+        if listed[i].Name != listed[j].Name {
+            return listed[i].Name < listed[j].Name
+        }
+        return listed[i].Type < listed[j].Type
+    })
+
+    if *asJSON {
+        enc := json.NewEncoder(os.Stdout)
+        enc.SetEscapeHTML(false)
+        enc.SetIndent("", "  ")
+        if listed == nil {
+            listed = []listedRecord{}
+        }
+        return enc.Encode(listed)
+    }
+
+    w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+    fmt.Fprintln(w, "NAME\tTYPE\tCONTENT\tTTL\tPROXIED\tRECORD ID\tIN CONFIG")
+    for _, record := range listed {
+        fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\t%s\t%s\n", record.Name, record.Type, record.Content, record.TTL,
+            record.Proxied, record.ID, yesNo(record.InConfig))
+    }
+    return w.Flush()
+}
+
+// listManagedRecords returns the records of the zone carrying one of the
+// configured tags or a comment from the default comment_template.
+func listManagedRecords(ctx context.Context, api DNSProvider, config *Config, zoneID string) ([]dnsRecord, error) {
+    var records []dnsRecord
+    err := withRetry(ctx, config, "list DNS records", func(ctx context.Context) error {
+        var err error
+        records, err = api.FindRecord(ctx, zoneID, "", "")
+        return err
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    var managed []dnsRecord
+    for _, record := range records {
+        if managedRecord(config, record) {
+            managed = append(managed, record)
+        }
+    }
+    return managed, nil
+}
+
+// managedRecord reports whether record looks like one gddns wrote.
+func managedRecord(config *Config, record dnsRecord) bool {
+    for _, tag := range record.Tags {
+        for _, managed := range config.tags() {
+            if tag == managed {
+                return true
+            }
+        }
+    }
+    prefix, _, _ := strings.Cut(defaultCommentTemplate, "{{")
+    return strings.HasPrefix(record.Comment, prefix)
+}
+
+func yesNo(b bool) string {
+    if b {
+        return "yes"
+    }
+    return "no"
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "syscall"
+)
+
+// errLocked is returned by acquireLock when another gddns holds the lock.
+var errLocked = errors.New("another instance is running")
+
+// lockPath is gddns.lock next to the config file, which is the data path
+// unless --config or --config-dir point elsewhere.
+func lockPath() string {
+    return filepath.Join(configHome(), "gddns.lock")
+}
+
+// acquireLock takes an exclusive flock so overlapping cron runs cannot race
+// on the config. The lock is released by calling the returned function, or
+// by the kernel when the process exits.
+func acquireLock() (func(), error) {
+    file, err := os.OpenFile(lockPath(), os.O_CREATE|os.O_RDWR, 0600)
+    if err != nil {
+        return nil, fmt.Errorf("opening lock file: %w", err)
+    }
+    if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+        file.Close()
+        if errors.Is(err, syscall.EWOULDBLOCK) {
+            return nil, errLocked
+        }
+        return nil, fmt.Errorf("locking %s: %w", lockPath(), err)
+    }
+
+    return func() {
+        syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+        file.Close()
+    }, nil
+}
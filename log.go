@@ -0,0 +1,138 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "gopkg.in/natefinch/lumberjack.v2"
+    "io"
+    "log/slog"
+    "os"
+    "time"
+)
+
+var logFormat string
+var logLevel string
+var quiet bool
+
+const (
+    defaultLogMaxSize    = 10
+    defaultLogMaxBackups = 5
+)
+
+// setupLogging installs the handler selected by --log-format, --log-level and
+// --quiet as the default slog logger, writing to out. The standard log package
+// writes through it as well.
+func setupLogging(out io.Writer, format, level string, quiet bool) error {
+    var minLevel slog.Level
+    if err := minLevel.UnmarshalText([]byte(level)); err != nil {
+        return fmt.Errorf("unknown log level %q, expected \"debug\", \"info\", \"warn\" or \"error\"", level)
+    }
+    opts := &slog.HandlerOptions{Level: minLevel}
+
+    var handler slog.Handler
+    switch format {
+    case "text":
+        handler = slog.NewTextHandler(out, opts)
+    case "json":
+        handler = slog.NewJSONHandler(out, opts)
+    default:
+        return fmt.Errorf("unknown log format %q, expected \"text\" or \"json\"", format)
+    }
+    if quiet {
+        handler = &quietHandler{Handler: handler}
+    }
+
+    slog.SetDefault(slog.New(handler))
+    return nil
+}
+
+// useLogFile sends the log to log_file, rotated once it grows past
+// log_max_size megabytes, and to stderr as well if log_stderr is set. Logging
+// stays on stderr when no log_file is configured.
+func useLogFile(config *Config) error {
+    if config.LogFile == "" {
+        return nil
+    }
+
+    var out io.Writer = &lumberjack.Logger{
+        Filename:   config.LogFile,
+        MaxSize:    config.logMaxSize(),
+        MaxBackups: config.logMaxBackups(),
+    }
+    if config.LogStderr {
+        out = io.MultiWriter(out, os.Stderr)
+    }
+    if err := setupLogging(out, logFormat, logLevel, quiet); err != nil {
+        return err
+    }
+    slog.Info("logging to file", "path", config.LogFile, "max_size_mb", config.logMaxSize(), "max_backups", config.logMaxBackups())
+    return nil
+}
+
+func (c *Config) logMaxSize() int {
+    if c.LogMaxSize > 0 {
+        return c.LogMaxSize
+    }
+    return defaultLogMaxSize
+}
+
+func (c *Config) logMaxBackups() int {
+    if c.LogMaxBackups > 0 {
+        return c.LogMaxBackups
+    }
+    return defaultLogMaxBackups
+}
+
+// changeEvents are the events still logged in quiet mode.
+var changeEvents = map[string]bool{
+    "record_created": true,
+    "record_updated": true,
+    "record_deleted": true,
+}
+
+// quietHandler drops informational records unless they report a change to a
+// DNS record. Warnings and errors always get through.
+type quietHandler struct {
+    slog.Handler
+}
+
+func (h *quietHandler) Handle(ctx context.Context, r slog.Record) error {
+    if r.Level >= slog.LevelWarn {
+        return h.Handler.Handle(ctx, r)
+    }
+
+    change := false
+    r.Attrs(func(attr slog.Attr) bool {
+        if attr.Key == "event" {
+            change = changeEvents[attr.Value.String()]
+            return false
+        }
+        return true
+    })
+    if !change {
+        return nil
+    }
+    return h.Handler.Handle(ctx, r)
+}
+
+func (h *quietHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+    return &quietHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *quietHandler) WithGroup(name string) slog.Handler {
+    return &quietHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// logEvent emits one of the well-known events (ip_fetched, record_updated,
+// record_created, ...) so JSON consumers can filter on the event field.
+func logEvent(event, msg string, args ...any) {
+    slog.Info(msg, append([]any{"event", event}, args...)...)
+}
+
+func logError(msg string, err error, args ...any) {
+    slog.Error(msg, append([]any{"event", "error", "error", err}, args...)...)
+}
+
+func durationMS(start time.Time) int64 {
+    return time.Since(start).Milliseconds()
+}
@@ -2,233 +2,737 @@ package main
 
 import (
     "context"
-    "encoding/json"
     "errors"
+    "flag"
     "fmt"
-    cloudflare "github.com/cloudflare/cloudflare-go"
     "github.com/joho/godotenv"
-    "io"
-    "log"
-    "net/http"
+    "golang.org/x/sync/errgroup"
+    "log/slog"
     "os"
+    "path/filepath"
     "strings"
+    "sync"
     "time"
 )
 
+// setDevMode is set with -ldflags "-X main.setDevMode=true" for development
+// builds, which keep their config in the working directory.
 var setDevMode string
 var dataPath string
 
-type Config struct {
-    *CfgFile
-    Env struct {
-        CFEmail  string
-        CFApiKey string
-        SysIP    string
+const defaultDataPath = "/etc/gddns"
+
+// Flag values, registered per subcommand in cmd.go
+var daemonMode bool
+var configFile string
+var dryRun bool
+var configDir string
+var forceUpdate bool
+var previewCreate bool
+var recreateOnTypeChange bool
+
+// updateRecord points the recordType record of rec at the current address.
+// It reports outcomeUnchanged when Cloudflare already had it, unless force is
+// set, which rewrites the record anyway to re-assert its TTL and proxied.
+func updateRecord(ctx context.Context, api DNSProvider, config *Config, rec *Record, recordType string, force bool) (outcome, error) {
+    spec := addressSpec(config, rec, recordType)
+    id := rec.recordID(recordType)
+
+    // What the record actually points at, which is last_ip unless it was
+    // changed outside of gddns
+    previous := rec.lastIP(recordType)
+
+    // Skip the write when Cloudflare already has the address, e.g. after the
+    // record was fixed by hand or state.json was lost
+    current, err := getRecord(ctx, api, config, rec, recordType)
+    if err == nil {
+        previous = current.Content
     }
+    if recordNotFound(err) {
+        return outcomeFailed, err
+    }
+    if err == nil && current.Type != spec.Type {
+        if recreateOnTypeChange {
+            return recreateRecord(ctx, api, config, rec, recordType, current)
+        }
+        slog.Warn("record has another type than configured, Cloudflare may reject changing it in place; "+
+            "use --recreate-on-type-change to replace it", "record", rec.CNAME, "type", recordType, "current_type", current.Type)
+    }
+    if err != nil {
+        slog.Warn("could not read the current record, updating anyway", "record", rec.CNAME, "type", recordType, "error", err)
+    } else if current.Content == spec.Content && current.Type == spec.Type && !force {
+        rec.setLastIP(recordType, spec.Content)
+        slog.Info("record already current", "record", rec.CNAME, "type", recordType, "ip", spec.Content)
+        return outcomeUnchanged, nil
+    }
+
+    if window := config.minUpdateInterval(); window > 0 && !force {
+        if since := time.Since(rec.lastUpdate(recordType)); since < window {
+            slog.Info("debounced, the record was updated too recently", "record", rec.CNAME, "type", recordType,
+                "ip", spec.Content, "min_update_interval", window, "retry_in", (window - since).Round(time.Second))
+            return outcomeSkipped, nil
+        }
+    }
+
+    if dryRun {
+        printDryRun("update", spec)
+        return outcomeUpdated, nil
+    }
+
+    start := time.Now()
+    if err := updateDNSRecord(ctx, api, config, rec, spec, id); err != nil {
+        return outcomeFailed, err
+    }
+    rec.setLastIP(recordType, spec.Content)
+    rec.setLastUpdate(recordType)
+    recordUpdateMetrics()
+    logEvent("record_updated", "DNS record updated", "record", rec.CNAME, "type", recordType, "old_ip", previous, "ip", spec.Content,
+        "record_id", id, "zone_id", rec.ZoneID, "duration_ms", durationMS(start))
+    // A forced rewrite of the same address is not an IP change
+    if previous != spec.Content {
+        appendIPHistory(config, rec, recordType, previous, spec.Content)
+        notifyIPChange(ctx, config, newIPChange(rec, previous, spec.Content))
+        runChangeCommand(ctx, config, rec, recordType, previous, spec.Content)
+    }
+    verifyPropagation(ctx, config, rec, recordType, spec.Content)
+
+    return outcomeUpdated, nil
 }
 
-type CfgFile struct {
-    Domain   string `json:"domain"`
-    CNAME    string `json:"cname"`
-    ZoneID   string `json:"zone_id"`
-    RecordID string `json:"record_id"`
+// recreateRecord replaces current, which has another type than the
+// recordType record of rec should have, by deleting it and creating the
+// record anew. The caller is responsible for saving the new record ID.
+func recreateRecord(ctx context.Context, api DNSProvider, config *Config, rec *Record, recordType string, current dnsRecord) (outcome, error) {
+    spec := addressSpec(config, rec, recordType)
+    if dryRun {
+        printDryRun("delete", recordSpec{Type: current.Type, Name: current.Name, Content: current.Content, TTL: current.TTL})
+        printDryRun("create", spec)
+        return outcomeCreated, nil
+    }
+
+    slog.Info("recreating record with the configured type", "record", rec.CNAME, "type", recordType,
+        "current_type", current.Type, "record_id", current.ID)
+    if err := deleteRecord(ctx, api, config, rec, current.Type, current.ID); err != nil {
+        return outcomeFailed, fmt.Errorf("deleting the %s record: %w", current.Type, err)
+    }
+    // The old record is gone, do not point at it whatever happens next
+    rec.setRecordID(recordType, "")
+    rec.setLastIP(recordType, "")
+
+    start := time.Now()
+    id, err := createDNSRecord(ctx, api, config, rec, spec)
+    if err != nil {
+        return outcomeFailed, fmt.Errorf("creating the %s record: %w", recordType, err)
+    }
+    rec.setRecordID(recordType, id)
+    rec.setLastIP(recordType, spec.Content)
+    rec.setLastUpdate(recordType)
+    recordUpdateMetrics()
+    logEvent("record_created", "DNS record created", "record", rec.CNAME, "type", recordType, "ip", spec.Content,
+        "record_id", id, "zone_id", rec.ZoneID, "duration_ms", durationMS(start))
+    appendIPHistory(config, rec, recordType, "", spec.Content)
+    verifyPropagation(ctx, config, rec, recordType, spec.Content)
+
+    return outcomeCreated, nil
 }
 
-func getPublicIP() (string, error) {
-    resp, err := http.Get("https://api.ipify.org?format=text")
+// findRecord returns the IDs of the existing recordType records with the
+// managed name. There can be several, e.g. round-robin A records.
+func findRecord(ctx context.Context, api DNSProvider, config *Config, rec *Record, recordType string) ([]dnsRecord, error) {
+    var records []dnsRecord
+    err := withRetry(ctx, config, "list DNS records", func(ctx context.Context) error {
+        var err error
+        records, err = api.FindRecord(ctx, rec.ZoneID, recordType, rec.fqdn())
+        return err
+    })
+
+    if zoneNotFound(err) {
+        return nil, configError(fmt.Errorf("zone %s not found, check zone_id of %s or remove it to discover the zone from the domain: %w",
+            rec.ZoneID, rec.fqdn(), err))
+    }
     if err != nil {
-        return "", err
+        return nil, err
     }
-    defer resp.Body.Close()
-    ip, err := io.ReadAll(resp.Body)
 
-    return string(ip), err
+    // No records is not an error, the caller creates the record
+    slog.Debug("listed DNS records", "record", rec.CNAME, "type", recordType, "zone_id", rec.ZoneID, "matches", len(records))
+    return records, nil
 }
 
-func loadConfigAndEnv(filename string) (*Config, error) {
-    file, err := os.Open(filename)
+// updateSRVRecord rewrites the SRV record of rec when its priority, weight,
+// port, target or name in the config no longer match what is deployed. It
+// reports whether it changed anything.
+func updateSRVRecord(ctx context.Context, api DNSProvider, config *Config, rec *Record) (bool, error) {
+    var record dnsRecord
+    err := withRetry(ctx, config, "get DNS record", func(ctx context.Context) error {
+        var err error
+        record, err = api.GetRecord(ctx, rec.ZoneID, rec.SRVRecordID)
+        return err
+    })
     if err != nil {
-        return nil, err
+        return false, err
     }
-    defer file.Close()
-    var config Config
-    if err := json.NewDecoder(file).Decode(&config); err != nil {
-        return nil, err
+
+    spec := srvSpec(config, rec)
+    changes := rec.srv().changes(rec.fqdn(), record)
+    if record.TTL != spec.TTL {
+        changes = append(changes, fmt.Sprintf("ttl %v -> %v", TTL(record.TTL), TTL(spec.TTL)))
+    }
+    if len(changes) == 0 {
+        slog.Debug("SRV record already current", "record", rec.CNAME, "record_id", rec.SRVRecordID)
+        return false, nil
     }
 
-    config.Env.CFApiKey = os.Getenv("CF_API_KEY")
-    config.Env.CFEmail = os.Getenv("CF_EMAIL")
-    if config.Env.CFApiKey == "" || config.Env.CFEmail == "" {
-        log.Fatal("Cloudflare API credentials are not set in environment variables.")
+    if dryRun {
+        printDryRun("update", spec)
+        return false, nil
     }
 
-    // Get current public IP
-    ip, err := getPublicIP()
+    start := time.Now()
+    if err := updateDNSRecord(ctx, api, config, rec, spec, rec.SRVRecordID); err != nil {
+        return false, err
+    }
+    logEvent("record_updated", "DNS record updated", "record", rec.CNAME, "type", "SRV", "changes", changes,
+        "record_id", rec.SRVRecordID, "zone_id", rec.ZoneID, "duration_ms", durationMS(start))
+    return true, nil
+}
+
+// findSRVRecord stores the ID of the SRV record matching the srv settings of
+// rec, if the zone has one.
+func findSRVRecord(ctx context.Context, api DNSProvider, config *Config, rec *Record) error {
+    srv := rec.srv()
+    name := strings.Join([]string{srv.Service, srv.Proto, rec.fqdn()}, ".")
+
+    var records []dnsRecord
+    err := withRetry(ctx, config, "list DNS records", func(ctx context.Context) error {
+        var err error
+        records, err = api.FindRecord(ctx, rec.ZoneID, "SRV", name)
+        return err
+    })
     if err != nil {
-        log.Fatalf("Error getting public IP: %v", err)
+        return err
     }
-    config.Env.SysIP = ip
 
-    return &config, nil
+    if len(records) == 1 {
+        rec.SRVRecordID = records[0].ID
+    }
+    return nil
 }
 
-func saveConfig(config *Config) error {
-    cfgdata := CfgFile{
-        Domain:   config.Domain,
-        CNAME:    config.CNAME,
-        ZoneID:   config.ZoneID,
-        RecordID: config.RecordID,
+// createSRVRecord creates the SRV record of rec on its own, for an address
+// record that already exists, e.g. when the SRV record could not be created
+// with it or was deleted since. It reports whether it created anything.
+func createSRVRecord(ctx context.Context, api DNSProvider, config *Config, rec *Record) (bool, error) {
+    if err := rec.srv().validate(); err != nil {
+        return false, err
     }
-    data, err := json.MarshalIndent(cfgdata, "", "  ")
-    if err != nil {
-        return err
+
+    spec := srvSpec(config, rec)
+    if dryRun {
+        printDryRun("create", spec)
+        return false, nil
+    }
+    if previewCreate {
+        return false, printCreatePreview(config, rec, spec)
     }
 
-    return os.WriteFile(strings.Join([]string{dataPath, "config.json"}, "/"), data, 0600)
+    start := time.Now()
+    id, err := createDNSRecord(ctx, api, config, rec, spec)
+    if err != nil {
+        return false, err
+    }
+    rec.SRVRecordID = id
+    logEvent("record_created", "DNS record created", "record", rec.CNAME, "type", "SRV",
+        "record_id", id, "zone_id", rec.ZoneID, "duration_ms", durationMS(start))
+    return true, nil
 }
 
-func updateRecord(api *cloudflare.API, config *Config) error {
-    // Update DNS record
-    recordParams := cloudflare.UpdateDNSRecordParams{
-        ID:      config.RecordID,
-        Type:    "A",
-        Name:    config.CNAME,
-        Content: config.Env.SysIP,
-        TTL:     120, // Example TTL; change if necessary
-        Comment: cloudflare.StringPtr("Automatically set by gddns"),
-        Proxied: cloudflare.BoolPtr(false),
+// createRecords creates the address record for recordType and, when withSRV
+// is set and an srv section is configured, the SRV record pointing at it.
+func createRecords(ctx context.Context, api DNSProvider, config *Config, rec *Record, recordType string, withSRV bool) error {
+    withSRV = withSRV && rec.srv() != nil && config.createSRV()
+    if withSRV {
+        if err := rec.srv().validate(); err != nil {
+            return err
+        }
     }
 
-    _, err := api.UpdateDNSRecord(context.Background(), cloudflare.ZoneIdentifier(config.ZoneID), recordParams)
+    address := addressSpec(config, rec, recordType)
+    if dryRun {
+        printDryRun("create", address)
+        if withSRV {
+            printDryRun("create", srvSpec(config, rec))
+        }
+        return nil
+    }
+    if previewCreate {
+        if err := printCreatePreview(config, rec, address); err != nil {
+            return err
+        }
+        if withSRV {
+            return printCreatePreview(config, rec, srvSpec(config, rec))
+        }
+        return nil
+    }
+
+    start := time.Now()
+    id, err := createDNSRecord(ctx, api, config, rec, address)
     if err != nil {
         return err
     }
+    duration := durationMS(start)
+
+    // The pair is only of use together, so the SRV record is created before
+    // the address record is saved, and a failure takes the address record
+    // back out; the next run then starts over instead of adopting it alone
+    var srvID string
+    var srvDuration int64
+    if withSRV {
+        start = time.Now()
+        srvID, err = createDNSRecord(ctx, api, config, rec, srvSpec(config, rec))
+        if err != nil {
+            return rollbackAddress(ctx, api, config, rec, recordType, id, address.Content, err)
+        }
+        srvDuration = durationMS(start)
+    }
+
+    rec.setRecordID(recordType, id)
+    rec.setLastIP(recordType, address.Content)
+    rec.setLastUpdate(recordType)
+    recordUpdateMetrics()
+    logEvent("record_created", "DNS record created", "record", rec.CNAME, "type", recordType, "ip", address.Content,
+        "record_id", id, "zone_id", rec.ZoneID, "duration_ms", duration)
+    if withSRV {
+        rec.SRVRecordID = srvID
+        logEvent("record_created", "DNS record created", "record", rec.CNAME, "type", "SRV",
+            "record_id", srvID, "zone_id", rec.ZoneID, "duration_ms", srvDuration)
+    }
+    appendIPHistory(config, rec, recordType, "", address.Content)
+    verifyPropagation(ctx, config, rec, recordType, address.Content)
 
     return nil
 }
 
-func findRecord(api *cloudflare.API, config *Config) error {
-    _, r, err := api.ListDNSRecords(context.Background(), cloudflare.ZoneIdentifier(config.ZoneID), cloudflare.ListDNSRecordsParams{
-        Type: "A",
-        Name: config.CNAME,
-    })
+// rollbackAddress deletes the address record createRecords made for an SRV
+// record that then failed. If that fails as well the record is kept as
+// managed, so the next run updates it rather than creating a second one, and
+// creates the missing SRV record next to it.
+func rollbackAddress(ctx context.Context, api DNSProvider, config *Config, rec *Record, recordType, id, ip string, srvErr error) error {
+    if err := deleteRecord(ctx, api, config, rec, recordType, id); err != nil {
+        rec.setRecordID(recordType, id)
+        rec.setLastIP(recordType, ip)
+        return fmt.Errorf("creating SRV record: %w (removing the %s record created with it also failed: %v)", srvErr, recordType, err)
+    }
+    slog.Warn("removed the new address record again, its SRV record could not be created", "record", rec.CNAME,
+        "type", recordType, "record_id", id)
+    return fmt.Errorf("creating SRV record: %w", srvErr)
+}
 
-    if err != nil {
+// deleteRecords removes the managed address, SRV and static records of rec
+// and clears their IDs. The caller is responsible for saving the config.
+func deleteRecords(ctx context.Context, api DNSProvider, config *Config, rec *Record) error {
+    for _, recordType := range rec.recordTypes() {
+        if err := deleteRecord(ctx, api, config, rec, recordType, rec.recordID(recordType)); err != nil {
+            return err
+        }
+        if !dryRun {
+            rec.setRecordID(recordType, "")
+            rec.setLastIP(recordType, "")
+        }
+    }
+
+    if err := deleteRecord(ctx, api, config, rec, "SRV", rec.SRVRecordID); err != nil {
         return err
     }
+    if !dryRun {
+        rec.SRVRecordID = ""
+    }
 
-    if r.Count != 0 {
-        return errors.New("record already exists")
+    for _, static := range rec.Static {
+        if err := deleteRecord(ctx, api, config, rec, static.Type, static.ID); err != nil {
+            return err
+        }
+        if !dryRun {
+            static.ID = ""
+        }
     }
 
     return nil
 }
 
-func createRecords(api *cloudflare.API, config *Config) error {
-    cnameFull := strings.Join([]string{config.CNAME, config.Domain}, ".")
+func deleteRecord(ctx context.Context, api DNSProvider, config *Config, rec *Record, recordType, id string) error {
+    if id == "" {
+        return nil
+    }
 
-    record, err := api.CreateDNSRecord(context.Background(), cloudflare.ZoneIdentifier(config.ZoneID), cloudflare.CreateDNSRecordParams{
-        Type:    "A",
-        Name:    config.CNAME,
-        Content: config.Env.SysIP,
-        TTL:     300,
-        Proxied: cloudflare.BoolPtr(false),
-        Comment: fmt.Sprintf("Automatically set by gddns at %s", time.Now().String()),
-    })
-    if err != nil {
-        return err
+    if dryRun {
+        slog.Info("dry run, DNS record not deleted", "record", rec.CNAME, "type", recordType, "record_id", id)
+        return nil
     }
 
-    _, err = api.CreateDNSRecord(context.Background(), cloudflare.ZoneIdentifier(config.ZoneID), cloudflare.CreateDNSRecordParams{
-        Type: "SRV",
-        Name: config.CNAME,
-        Data: map[string]interface{}{
-            "service":  "_minecraft",
-            "proto":    "_tcp",
-            "name":     cnameFull,
-            "priority": 0,
-            "weight":   5,
-            "port":     25565,
-            "target":   cnameFull,
-        },
-        TTL:     900,
-        Proxied: cloudflare.BoolPtr(false),
-        Comment: fmt.Sprintf("Automatically set by gddns at %s", time.Now().String()),
+    err := withRetry(ctx, config, "delete DNS record", func(ctx context.Context) error {
+        return api.DeleteRecord(ctx, rec.ZoneID, id)
     })
-
     if err != nil {
         return err
     }
-
-    config.RecordID = record.ID
+    logEvent("record_deleted", "DNS record deleted", "record", rec.CNAME, "type", recordType, "record_id", id, "zone_id", rec.ZoneID)
 
     return nil
 }
 
-func setup() (api *cloudflare.API, config *Config, err error) {
-    config, err = loadConfigAndEnv(strings.Join([]string{dataPath, "config.json"}, "/"))
+// printDryRun logs the parameters a DNS mutation would have been sent with.
+func printDryRun(action string, spec recordSpec) {
+    slog.Info("dry run, DNS record not changed", "action", action, "type", spec.Type, "name", spec.Name,
+        "content", spec.content(), "ttl", TTL(spec.TTL), "proxied", spec.proxied())
+}
+
+func setup() (clients *clientSet, config *Config, err error) {
+    config, err = loadConfigAndEnv(configPath())
     if err != nil {
-        // Wrap the error with context, but do not log.Fatal
-        return nil, nil, fmt.Errorf("error loading configuration: %w", err)
+        return nil, nil, configError(fmt.Errorf("loading configuration: %w", err))
+    }
+    if err := useLogFile(config); err != nil {
+        return nil, nil, configError(err)
     }
+    return newClientSet(config), config, nil
+}
 
-    api, err = cloudflare.New(config.Env.CFApiKey, config.Env.CFEmail)
+// loadConfigDir loads every *.json file in --config-dir as a config of its
+// own. A file that fails to load is skipped with a warning so one broken file
+// does not stop the others.
+func loadConfigDir() ([]*Config, error) {
+    files, err := filepath.Glob(filepath.Join(configDir, "*.json"))
     if err != nil {
-        return nil, nil, fmt.Errorf("error initializing Cloudflare client: %w", err)
+        return nil, configError(err)
+    }
+
+    var configs []*Config
+    for _, file := range files {
+        // Record backups are written next to the config files
+        if strings.HasPrefix(filepath.Base(file), backupPrefix) {
+            continue
+        }
+        config, err := loadConfigAndEnv(file)
+        if err != nil {
+            slog.Warn("skipping config file that failed to load", "path", file, "error", err)
+            continue
+        }
+        slog.Info("loaded config file", "path", file, "records", len(config.Records))
+        configs = append(configs, config)
+    }
+    if len(configs) == 0 {
+        return nil, configError(fmt.Errorf("no usable config files in %s", configDir))
     }
+    // There is only one log, the first file that sets log_file decides it
+    for _, config := range configs {
+        if config.LogFile != "" {
+            if err := useLogFile(config); err != nil {
+                return nil, configError(err)
+            }
+            break
+        }
+    }
+    return configs, nil
+}
 
-    return api, config, nil
+// configHome is the directory holding the config files, where .env and the
+// lock file live.
+func configHome() string {
+    if configDir != "" {
+        return configDir
+    }
+    return filepath.Dir(configPath())
 }
 
-func init() {
-    if setDevMode == "false" {
-        dataPath = "/etc/gddns"
+// configPath returns the --config path, or config.json in the data path.
+func configPath() string {
+    if configFile != "" {
+        return configFile
+    }
+    return strings.Join([]string{dataPath, "config.json"}, "/")
+}
 
-    } else {
-        dataPath = "."
+// loadDotEnv loads the .env next to the config file. A missing .env is fine,
+// credentials may come from the real environment.
+func loadDotEnv() error {
+    err := godotenv.Load(filepath.Join(configHome(), ".env"))
+    if err != nil && !errors.Is(err, os.ErrNotExist) {
+        return err
     }
-    fmt.Printf("Using data path: %s\n", dataPath)
+    return nil
+}
 
-    err := godotenv.Load(strings.Join([]string{dataPath, ".env.example"}, "/"))
-    if err != nil {
-        log.Fatalf("Error loading .env.example file: %v", err)
+// resolveDataPath picks the data path from GDDNS_DATA_PATH, then the
+// setDevMode ldflag, then /etc/gddns, and returns which of them it used.
+func resolveDataPath() (path, source string, err error) {
+    if path := os.Getenv("GDDNS_DATA_PATH"); path != "" {
+        return path, "GDDNS_DATA_PATH", nil
+    }
+    switch setDevMode {
+    case "true":
+        return ".", "dev mode build", nil
+    case "false":
+        return defaultDataPath, "release build", nil
+    case "":
+        return defaultDataPath, "default", nil
     }
+    return "", "", fmt.Errorf("gddns was built with setDevMode %q, expected \"true\" or \"false\"", setDevMode)
 }
 
 func main() {
-    api, config, err := setup()
-    if err != nil {
-        log.Fatalf("Setup failed: %v", err)
+    if err := run(os.Args[1:]); err != nil {
+        if errors.Is(err, flag.ErrHelp) {
+            os.Exit(exitOK)
+        }
+        logError("gddns failed", err)
+        os.Exit(exitCode(err))
+    }
+}
+
+// run dispatches to the subcommand named by the first argument. Errors are
+// mapped to an exit code by main, see exitCode.
+func run(args []string) error {
+    name := "update"
+    if len(args) > 0 && (args[0] == "--version" || args[0] == "-version") {
+        name, args = "version", args[1:]
+    } else if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+        name, args = args[0], args[1:]
     }
 
-    if config.RecordID != "" {
-        if err := updateRecord(api, config); err != nil {
-            log.Fatalf("Error updating DNS record: %v", err)
+    command, ok := commands[name]
+    if !ok {
+        usage()
+        return configError(fmt.Errorf("unknown command %q", name))
+    }
+    return command(args)
+}
+
+const defaultConcurrency = 4
+
+func (c *Config) concurrency() int {
+    if c.Concurrency > 0 {
+        return c.Concurrency
+    }
+    return defaultConcurrency
+}
+
+// runOnce brings every record up to date, rewriting them all when force is
+// set, and saves the config if anything changed.
+func runOnce(ctx context.Context, clients *clientSet, config *Config, force bool) error {
+    changed, err := updateRecords(ctx, clients, config, force)
+    if changed {
+        if saveErr := saveChanges(config); saveErr != nil {
+            err = errors.Join(err, fmt.Errorf("saving config: %w", saveErr))
         }
-        fmt.Println("DNS record updated successfully.")
-        return
     }
+    return err
+}
 
-    if config.RecordID == "" {
-        fmt.Println("No DNS record ID was set...")
-        err := findRecord(api, config)
-        if err != nil {
-            log.Fatalf("Error veryifying dns state: %v", err)
+// updateRecords brings every record up to date, up to concurrency records at
+// a time, and reports whether any changed in a way that needs saving. A
+// failing record does not stop the others; the caller saves the config once
+// at the end so the workers never race on it, and anything created by a run
+// that dies before saving is adopted by findRecord on the next run.
+func updateRecords(ctx context.Context, clients *clientSet, config *Config, force bool) (bool, error) {
+    var (
+        mu       sync.Mutex
+        changed  bool
+        failures []error
+    )
+    summary := newRunSummary()
+
+    var g errgroup.Group
+    g.SetLimit(config.concurrency())
+    for _, rec := range config.Records {
+        rec := rec
+        g.Go(func() error {
+            api, err := clients.forRecord(rec)
+            recChanged := false
+            if err == nil {
+                recChanged, err = runRecord(ctx, api, config, rec, force, summary)
+            } else {
+                for range rec.recordTypes() {
+                    summary.add(outcomeFailed)
+                }
+            }
+
+            mu.Lock()
+            defer mu.Unlock()
+            changed = changed || recChanged
+            if err != nil {
+                logError("error updating record", err, "record", rec.CNAME)
+                errorsTotal.WithLabelValues("update").Inc()
+                failures = append(failures, err)
+            }
+            return nil
+        })
+    }
+    g.Wait()
+
+    summary.log()
+    return changed, errors.Join(failures...)
+}
+
+// runRecord brings rec up to date and reports whether anything in it changed
+// that needs saving. The outcome of each address record goes to summary.
+func runRecord(ctx context.Context, api DNSProvider, config *Config, rec *Record, force bool, summary *runSummary) (bool, error) {
+    changed := false
+    if rec.ZoneID == "" {
+        if err := resolveZoneID(ctx, api, config, rec); err != nil {
+            for range rec.recordTypes() {
+                summary.add(outcomeFailed)
+            }
+            return false, fmt.Errorf("discovering zone ID for %s: %w", rec.fqdn(), apiError(err))
+        }
+        slog.Info("discovered zone ID", "domain", rec.Domain, "zone_id", rec.ZoneID)
+        changed = true
+    } else if err := checkZone(ctx, api, config, rec); err != nil {
+        if rec.cachedZone && zoneNotFound(err) {
+            rec.dropCachedZone()
+            return runRecord(ctx, api, config, rec, force, summary)
+        }
+        for range rec.recordTypes() {
+            summary.add(outcomeFailed)
         }
+        return false, fmt.Errorf("checking zone of %s: %w", rec.fqdn(), err)
+    }
 
-        fmt.Println("new DNS record supplied, assuming new DNS record...")
-        err = createRecords(api, config)
+    // On a fresh setup the SRV record is created alongside the first record
+    withSRV := rec.RecordID == "" && rec.RecordIDv6 == "" && rec.SRVRecordID == ""
+    createdSRV := withSRV
+
+    // Configs written before srv_record_id existed lost the ID, pick it up
+    // again. An SRV record that is not there at all, because creating it
+    // failed or it was deleted, is created next to the existing address record.
+    if !withSRV && rec.srv() != nil && rec.SRVRecordID == "" && config.createSRV() {
+        if err := findSRVRecord(ctx, api, config, rec); err != nil {
+            logError("error looking up existing SRV record", err, "record", rec.CNAME)
+        } else if rec.SRVRecordID != "" {
+            slog.Info("adopted existing SRV record", "record", rec.CNAME, "record_id", rec.SRVRecordID)
+            changed = true
+        } else if created, err := createSRVRecord(ctx, api, config, rec); err != nil {
+            logError("error creating missing SRV record", err, "record", rec.CNAME)
+        } else if created {
+            createdSRV = true
+            changed = true
+        }
+    }
+
+    // The outcomes go to summary once every record type is done, a retry
+    // with the zone looked up again counts them itself
+    var outcomes []outcome
+    for _, recordType := range rec.recordTypes() {
+        result, typeChanged, err := runRecordType(ctx, api, config, rec, recordType, withSRV, force)
+        changed = changed || typeChanged
+        if err != nil && rec.cachedZone && zoneNotFound(err) {
+            rec.dropCachedZone()
+            _, err := runRecord(ctx, api, config, rec, force, summary)
+            return true, err
+        }
+        outcomes = append(outcomes, result)
         if err != nil {
-            log.Fatalf("Error creating records: %v", err)
+            summary.add(outcomes...)
+            return changed, err
         }
+        withSRV = false
+    }
+    summary.add(outcomes...)
+
+    // A record created in this run is already current
+    if !createdSRV && rec.srv() != nil && rec.SRVRecordID != "" && config.createSRV() {
+        if _, err := updateSRVRecord(ctx, api, config, rec); err != nil {
+            return changed, fmt.Errorf("updating %s SRV record: %w", rec.fqdn(), apiError(err))
+        }
+    }
 
-        fmt.Println("DNS record created successfully...")
-        err = saveConfig(config)
+    if len(rec.Static) == 0 {
+        return changed, nil
+    }
+    staticChanged, err := syncStaticRecords(ctx, api, config, rec)
+    changed = changed || staticChanged
+    if err != nil {
+        return changed, fmt.Errorf("%s: %w", rec.fqdn(), apiError(err))
+    }
+    return changed, nil
+}
+
+// runRecordType brings the recordType record of rec up to date and returns
+// its outcome.
+func runRecordType(ctx context.Context, api DNSProvider, config *Config, rec *Record, recordType string, withSRV, force bool) (outcome, bool, error) {
+    if config.content(rec, recordType) == "" {
+        slog.Warn("no public address for this record type, skipping it", "record", rec.CNAME, "type", recordType)
+        return outcomeSkipped, false, nil
+    }
+    return upsertRecord(ctx, api, config, rec, recordType, withSRV, force)
+}
+
+// upsertRecord makes sure the recordType record of rec exists and points at
+// the current address, whether or not its ID is known yet. Without an ID it
+// looks the record up by type and name, adopting it when there is one and
+// creating it when there is none; a saved ID whose record was deleted is
+// dropped and looked up again. It reports whether the ID or last IP changed
+// and so needs saving.
+func upsertRecord(ctx context.Context, api DNSProvider, config *Config, rec *Record, recordType string, withSRV, force bool) (outcome, bool, error) {
+    adopted := false
+    if rec.recordID(recordType) == "" {
+        slog.Info("no record ID set, looking for an existing record", "record", rec.CNAME, "type", recordType)
+        records, err := findRecord(ctx, api, config, rec, recordType)
         if err != nil {
-            log.Fatalf("Error saving config: %v", err)
+            return outcomeFailed, false, fmt.Errorf("verifying DNS state of %s %s record: %w", rec.fqdn(), recordType, apiError(err))
+        }
+        if len(records) > 1 {
+            field := "record_id"
+            if recordType == "AAAA" {
+                field = "record_id_v6"
+            }
+            ids := make([]string, 0, len(records))
+            for _, record := range records {
+                ids = append(ids, record.ID)
+            }
+            return outcomeFailed, false, configError(fmt.Errorf("ambiguous: %d %s records named %s (%s), set %s to the one gddns should manage",
+                len(ids), recordType, rec.fqdn(), strings.Join(ids, ", "), field))
         }
-        fmt.Println("DNS record saved successfully.")
 
-        return
+        if len(records) == 0 {
+            err = createRecords(ctx, api, config, rec, recordType, withSRV)
+            if err != nil {
+                // The address record stays when the SRV record failed and
+                // it could not be removed again
+                return outcomeFailed, rec.recordID(recordType) != "", fmt.Errorf("creating %s %s record: %w", rec.fqdn(), recordType, apiError(err))
+            }
+            // A preview creates nothing, the config has nothing new to save
+            return outcomeCreated, !previewCreate, nil
+        }
+
+        if config.BackupOnAdopt {
+            if err := backupRecord(config, rec, records[0]); err != nil {
+                return outcomeFailed, false, fmt.Errorf("backing up %s %s record before adopting it: %w", rec.fqdn(), recordType, err)
+            }
+        }
+        rec.setRecordID(recordType, records[0].ID)
+        slog.Info("adopted existing record", "record", rec.CNAME, "type", recordType, "record_id", rec.recordID(recordType))
+        adopted = true
+    }
+
+    if config.content(rec, recordType) == rec.lastIP(recordType) && !force {
+        slog.Info("IP unchanged, nothing to do", "record", rec.CNAME, "type", recordType)
+        return outcomeUnchanged, adopted, nil
+    }
+    result, err := updateRecord(ctx, api, config, rec, recordType, force)
+    // A record adopted just now cannot have gone missing since, only heal a
+    // saved ID
+    if recordNotFound(err) && !adopted {
+        slog.Warn("managed record no longer exists, finding or recreating it", "record", rec.CNAME,
+            "type", recordType, "record_id", rec.recordID(recordType))
+        rec.setRecordID(recordType, "")
+        rec.setLastIP(recordType, "")
+        result, _, err := upsertRecord(ctx, api, config, rec, recordType, withSRV, force)
+        // The ID changed either way, the config needs saving
+        return result, true, err
+    }
+    if err != nil {
+        return outcomeFailed, adopted, fmt.Errorf("updating %s %s record: %w", rec.fqdn(), recordType, apiError(err))
     }
+    return result, true, nil
 }
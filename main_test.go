@@ -0,0 +1,519 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    cloudflare "github.com/cloudflare/cloudflare-go"
+    "net/http"
+    "testing"
+)
+
+// fakeDNS is an in-memory Cloudflare zone. Each call fails with the error in
+// errs under its operation and record type, e.g. "create SRV", or under the
+// operation alone.
+type fakeDNS struct {
+    records map[string]cloudflare.DNSRecord
+    errs    map[string]error
+    nextID  int
+    calls   []string
+}
+
+var _ DNSClient = (*fakeDNS)(nil)
+
+func newFakeDNS(records ...cloudflare.DNSRecord) *fakeDNS {
+    f := &fakeDNS{records: make(map[string]cloudflare.DNSRecord), errs: make(map[string]error)}
+    for _, record := range records {
+        f.records[record.ID] = record
+    }
+    return f
+}
+
+func (f *fakeDNS) fail(op, recordType string) error {
+    f.calls = append(f.calls, op+" "+recordType)
+    if err, ok := f.errs[op+" "+recordType]; ok {
+        return err
+    }
+    return f.errs[op]
+}
+
+func (f *fakeDNS) ListDNSRecords(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.ListDNSRecordsParams) ([]cloudflare.DNSRecord, *cloudflare.ResultInfo, error) {
+    if err := f.fail("list", params.Type); err != nil {
+        return nil, nil, err
+    }
+    var found []cloudflare.DNSRecord
+    for _, record := range f.records {
+        if (params.Type == "" || record.Type == params.Type) && (params.Name == "" || record.Name == params.Name) {
+            found = append(found, record)
+        }
+    }
+    return found, &cloudflare.ResultInfo{}, nil
+}
+
+func (f *fakeDNS) GetDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, recordID string) (cloudflare.DNSRecord, error) {
+    record, ok := f.records[recordID]
+    if err := f.fail("get", record.Type); err != nil {
+        return cloudflare.DNSRecord{}, err
+    }
+    if !ok {
+        return cloudflare.DNSRecord{}, errRecordMissing
+    }
+    return record, nil
+}
+
+func (f *fakeDNS) CreateDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.CreateDNSRecordParams) (cloudflare.DNSRecord, error) {
+    if err := f.fail("create", params.Type); err != nil {
+        return cloudflare.DNSRecord{}, err
+    }
+    f.nextID++
+    record := cloudflare.DNSRecord{
+        ID:       fmt.Sprintf("new%d", f.nextID),
+        Type:     params.Type,
+        Name:     params.Name,
+        Content:  params.Content,
+        Data:     params.Data,
+        Priority: params.Priority,
+        TTL:      params.TTL,
+        Proxied:  params.Proxied,
+        Comment:  params.Comment,
+        Tags:     params.Tags,
+    }
+    f.records[record.ID] = record
+    return record, nil
+}
+
+func (f *fakeDNS) UpdateDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.UpdateDNSRecordParams) (cloudflare.DNSRecord, error) {
+    if err := f.fail("update", params.Type); err != nil {
+        return cloudflare.DNSRecord{}, err
+    }
+    if _, ok := f.records[params.ID]; !ok {
+        return cloudflare.DNSRecord{}, errRecordMissing
+    }
+    record := cloudflare.DNSRecord{
+        ID:       params.ID,
+        Type:     params.Type,
+        Name:     params.Name,
+        Content:  params.Content,
+        Data:     params.Data,
+        Priority: params.Priority,
+        TTL:      params.TTL,
+        Proxied:  params.Proxied,
+        Tags:     params.Tags,
+    }
+    if params.Comment != nil {
+        record.Comment = *params.Comment
+    }
+    f.records[params.ID] = record
+    return record, nil
+}
+
+func (f *fakeDNS) DeleteDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, recordID string) error {
+    if err := f.fail("delete", f.records[recordID].Type); err != nil {
+        return err
+    }
+    if _, ok := f.records[recordID]; !ok {
+        return errRecordMissing
+    }
+    delete(f.records, recordID)
+    return nil
+}
+
+func (f *fakeDNS) ListZonesContext(ctx context.Context, opts ...cloudflare.ReqOption) (cloudflare.ZonesResponse, error) {
+    return cloudflare.ZonesResponse{Result: []cloudflare.Zone{{ID: "zone", Name: "example.com"}}}, nil
+}
+
+func (f *fakeDNS) ZoneDetails(ctx context.Context, zoneID string) (cloudflare.Zone, error) {
+    return cloudflare.Zone{ID: zoneID, Name: "example.com"}, nil
+}
+
+func (f *fakeDNS) VerifyAPIToken(ctx context.Context) (cloudflare.APITokenVerifyBody, error) {
+    return cloudflare.APITokenVerifyBody{Status: "active"}, nil
+}
+
+// byType returns the records of the given type.
+func (f *fakeDNS) byType(recordType string) []cloudflare.DNSRecord {
+    var found []cloudflare.DNSRecord
+    for _, record := range f.records {
+        if record.Type == recordType {
+            found = append(found, record)
+        }
+    }
+    return found
+}
+
+var (
+    errRecordMissing = &cloudflare.Error{StatusCode: http.StatusNotFound, ErrorCodes: []int{recordNotFoundCode},
+        Errors: []cloudflare.ResponseInfo{{Code: recordNotFoundCode, Message: "Record does not exist."}}}
+    errServer = &cloudflare.Error{StatusCode: http.StatusBadRequest, ErrorCodes: []int{9999},
+        Errors: []cloudflare.ResponseInfo{{Code: 9999, Message: "something went wrong"}}}
+)
+
+const (
+    testName = "home.example.com"
+    testIP   = "192.0.2.10"
+    oldIP    = "192.0.2.1"
+)
+
+func aRecord(id, ip string) cloudflare.DNSRecord {
+    return cloudflare.DNSRecord{ID: id, Type: "A", Name: testName, Content: ip, TTL: 1}
+}
+
+// newTestConfig returns a config with a single A record for testName, the
+// current address being testIP, and a fresh state.
+func newTestConfig(t *testing.T) (*Config, *Record) {
+    t.Helper()
+    t.Setenv("GDDNS_DATA_PATH", t.TempDir())
+    stateMu.Lock()
+    state = &State{Records: make(map[string]*recordState)}
+    stateMu.Unlock()
+
+    rec := &Record{Domain: "example.com", CNAME: "home", ZoneID: "zone"}
+    config := &Config{CfgFile: &CfgFile{Records: []*Record{rec}}}
+    config.setIP("A", testIP)
+    return config, rec
+}
+
+func TestUpsertRecord(t *testing.T) {
+    tests := []struct {
+        name     string
+        records  []cloudflare.DNSRecord
+        recordID string
+        lastIP   string
+        errs     map[string]error
+
+        want        outcome
+        wantChanged bool
+        wantErr     bool
+        wantID      string
+        wantContent map[string]string
+    }{
+        {
+            name:        "update",
+            records:     []cloudflare.DNSRecord{aRecord("r1", oldIP)},
+            recordID:    "r1",
+            lastIP:      oldIP,
+            want:        outcomeUpdated,
+            wantChanged: true,
+            wantID:      "r1",
+            wantContent: map[string]string{"r1": testIP},
+        },
+        {
+            name:     "last IP current",
+            records:  []cloudflare.DNSRecord{aRecord("r1", testIP)},
+            recordID: "r1",
+            lastIP:   testIP,
+            want:     outcomeUnchanged,
+            wantID:   "r1",
+        },
+        {
+            name:        "already current in the zone",
+            records:     []cloudflare.DNSRecord{aRecord("r1", testIP)},
+            recordID:    "r1",
+            want:        outcomeUnchanged,
+            wantChanged: true,
+            wantID:      "r1",
+            wantContent: map[string]string{"r1": testIP},
+        },
+        {
+            name:        "create",
+            want:        outcomeCreated,
+            wantChanged: true,
+            wantID:      "new1",
+            wantContent: map[string]string{"new1": testIP},
+        },
+        {
+            name:        "adopt existing",
+            records:     []cloudflare.DNSRecord{aRecord("r1", oldIP)},
+            want:        outcomeUpdated,
+            wantChanged: true,
+            wantID:      "r1",
+            wantContent: map[string]string{"r1": testIP},
+        },
+        {
+            name:        "recreate deleted record",
+            recordID:    "gone",
+            lastIP:      oldIP,
+            want:        outcomeCreated,
+            wantChanged: true,
+            wantID:      "new1",
+            wantContent: map[string]string{"new1": testIP},
+        },
+        {
+            name:    "ambiguous",
+            records: []cloudflare.DNSRecord{aRecord("r1", oldIP), aRecord("r2", oldIP)},
+            want:    outcomeFailed,
+            wantErr: true,
+        },
+        {
+            name:    "list error",
+            errs:    map[string]error{"list": errServer},
+            want:    outcomeFailed,
+            wantErr: true,
+        },
+        {
+            name:    "create error",
+            errs:    map[string]error{"create": errServer},
+            want:    outcomeFailed,
+            wantErr: true,
+        },
+        {
+            name:        "update error",
+            records:     []cloudflare.DNSRecord{aRecord("r1", oldIP)},
+            recordID:    "r1",
+            lastIP:      oldIP,
+            errs:        map[string]error{"update": errServer},
+            want:        outcomeFailed,
+            wantErr:     true,
+            wantID:      "r1",
+            wantContent: map[string]string{"r1": oldIP},
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            config, rec := newTestConfig(t)
+            rec.RecordID = tt.recordID
+            if tt.lastIP != "" {
+                rec.setLastIP("A", tt.lastIP)
+            }
+            fake := newFakeDNS(tt.records...)
+            for op, err := range tt.errs {
+                fake.errs[op] = err
+            }
+
+            got, changed, err := upsertRecord(context.Background(), &cloudflareProvider{api: fake}, config, rec, "A", false, false)
+            if (err != nil) != tt.wantErr {
+                t.Fatalf("upsertRecord() error = %v, want error %t", err, tt.wantErr)
+            }
+            if got != tt.want {
+                t.Errorf("upsertRecord() = %v, want %v", got, tt.want)
+            }
+            if changed != tt.wantChanged {
+                t.Errorf("upsertRecord() changed = %t, want %t", changed, tt.wantChanged)
+            }
+            if rec.RecordID != tt.wantID {
+                t.Errorf("record_id = %q, want %q", rec.RecordID, tt.wantID)
+            }
+            for id, content := range tt.wantContent {
+                if got := fake.records[id].Content; got != content {
+                    t.Errorf("record %s content = %q, want %q", id, got, content)
+                }
+            }
+            if !tt.wantErr && rec.lastIP("A") != testIP {
+                t.Errorf("last IP = %q, want %q", rec.lastIP("A"), testIP)
+            }
+        })
+    }
+}
+
+func TestUpdateRecord(t *testing.T) {
+    tests := []struct {
+        name    string
+        content string
+        force   bool
+        errs    map[string]error
+
+        want        outcome
+        wantErr     bool
+        wantUpdates int
+    }{
+        {name: "changed", content: oldIP, want: outcomeUpdated, wantUpdates: 1},
+        {name: "current", content: testIP, want: outcomeUnchanged},
+        {name: "forced", content: testIP, force: true, want: outcomeUpdated, wantUpdates: 1},
+        {name: "read error", content: oldIP, errs: map[string]error{"get": errServer}, want: outcomeUpdated, wantUpdates: 1},
+        {name: "update error", content: oldIP, errs: map[string]error{"update": errServer}, want: outcomeFailed, wantErr: true, wantUpdates: 1},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            config, rec := newTestConfig(t)
+            rec.RecordID = "r1"
+            fake := newFakeDNS(aRecord("r1", tt.content))
+            for op, err := range tt.errs {
+                fake.errs[op] = err
+            }
+
+            got, err := updateRecord(context.Background(), &cloudflareProvider{api: fake}, config, rec, "A", tt.force)
+            if (err != nil) != tt.wantErr {
+                t.Fatalf("updateRecord() error = %v, want error %t", err, tt.wantErr)
+            }
+            if got != tt.want {
+                t.Errorf("updateRecord() = %v, want %v", got, tt.want)
+            }
+            updates := 0
+            for _, call := range fake.calls {
+                if call == "update A" {
+                    updates++
+                }
+            }
+            if updates != tt.wantUpdates {
+                t.Errorf("updateRecord() made %d updates, want %d", updates, tt.wantUpdates)
+            }
+            if !tt.wantErr && fake.records["r1"].Content != testIP {
+                t.Errorf("record content = %q, want %q", fake.records["r1"].Content, testIP)
+            }
+        })
+    }
+}
+
+func TestUpdateRecordNotFound(t *testing.T) {
+    config, rec := newTestConfig(t)
+    rec.RecordID = "gone"
+
+    _, err := updateRecord(context.Background(), &cloudflareProvider{api: newFakeDNS()}, config, rec, "A", false)
+    if !recordNotFound(err) {
+        t.Fatalf("updateRecord() error = %v, want a record not found error", err)
+    }
+    var cfErr *cloudflare.Error
+    if !errors.As(err, &cfErr) {
+        t.Errorf("updateRecord() error = %v, want it to wrap the Cloudflare error", err)
+    }
+}
+
+func TestCreateRecords(t *testing.T) {
+    tests := []struct {
+        name    string
+        srv     *SRVConfig
+        withSRV bool
+        errs    map[string]error
+
+        wantErr bool
+        wantA   int
+        wantSRV int
+    }{
+        {name: "address only", wantA: 1},
+        {name: "with SRV", srv: testSRV, withSRV: true, wantA: 1, wantSRV: 1},
+        {name: "SRV not wanted", srv: testSRV, wantA: 1},
+        {name: "create error", errs: map[string]error{"create": errServer}, wantErr: true},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            config, rec := newTestConfig(t)
+            rec.SRV = tt.srv
+            fake := newFakeDNS()
+            for op, err := range tt.errs {
+                fake.errs[op] = err
+            }
+
+            err := createRecords(context.Background(), &cloudflareProvider{api: fake}, config, rec, "A", tt.withSRV)
+            if (err != nil) != tt.wantErr {
+                t.Fatalf("createRecords() error = %v, want error %t", err, tt.wantErr)
+            }
+            if got := len(fake.byType("A")); got != tt.wantA {
+                t.Errorf("created %d A records, want %d", got, tt.wantA)
+            }
+            if got := len(fake.byType("SRV")); got != tt.wantSRV {
+                t.Errorf("created %d SRV records, want %d", got, tt.wantSRV)
+            }
+            if tt.wantErr {
+                if rec.RecordID != "" {
+                    t.Errorf("record_id = %q, want it unset", rec.RecordID)
+                }
+                return
+            }
+
+            if record := fake.records[rec.RecordID]; record.Content != testIP || record.Name != testName {
+                t.Errorf("record_id %q points at %+v, want the new A record", rec.RecordID, record)
+            }
+            if tt.wantSRV > 0 && fake.records[rec.SRVRecordID].Type != "SRV" {
+                t.Errorf("srv_record_id %q does not point at the SRV record", rec.SRVRecordID)
+            }
+            if rec.lastIP("A") != testIP {
+                t.Errorf("last IP = %q, want %q", rec.lastIP("A"), testIP)
+            }
+        })
+    }
+}
+
+var testSRV = &SRVConfig{Service: "_minecraft", Proto: "_tcp", Port: 25565}
+
+func TestCreateRecordsSRVFails(t *testing.T) {
+    tests := []struct {
+        name string
+        errs map[string]error
+
+        wantA  int
+        wantID bool
+    }{
+        {
+            name: "address record rolled back",
+            errs: map[string]error{"create SRV": errServer},
+        },
+        {
+            name:   "rollback fails",
+            errs:   map[string]error{"create SRV": errServer, "delete A": errServer},
+            wantA:  1,
+            wantID: true,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            config, rec := newTestConfig(t)
+            rec.SRV = testSRV
+            fake := newFakeDNS()
+            for op, err := range tt.errs {
+                fake.errs[op] = err
+            }
+
+            err := createRecords(context.Background(), &cloudflareProvider{api: fake}, config, rec, "A", true)
+            if err == nil {
+                t.Fatal("createRecords() succeeded, want the SRV error")
+            }
+            if got := len(fake.byType("A")); got != tt.wantA {
+                t.Errorf("%d A records left, want %d", got, tt.wantA)
+            }
+            if len(fake.byType("SRV")) != 0 || rec.SRVRecordID != "" {
+                t.Errorf("SRV record created, srv_record_id = %q", rec.SRVRecordID)
+            }
+
+            if !tt.wantID {
+                if rec.RecordID != "" || rec.lastIP("A") != "" {
+                    t.Errorf("record_id = %q, last IP = %q, want both unset", rec.RecordID, rec.lastIP("A"))
+                }
+                return
+            }
+            if rec.RecordID == "" || fake.records[rec.RecordID].Type != "A" {
+                t.Errorf("record_id = %q, want the A record that could not be removed", rec.RecordID)
+            }
+            if rec.lastIP("A") != testIP {
+                t.Errorf("last IP = %q, want %q", rec.lastIP("A"), testIP)
+            }
+        })
+    }
+}
+
+// A kept address record must not stop the SRV record from being created on
+// a later run.
+func TestRunRecordCreatesMissingSRV(t *testing.T) {
+    config, rec := newTestConfig(t)
+    rec.SRV = testSRV
+    fake := newFakeDNS()
+    fake.errs["create SRV"] = errServer
+    fake.errs["delete A"] = errServer
+    api := &cloudflareProvider{api: fake}
+
+    if _, err := runRecord(context.Background(), api, config, rec, false, newRunSummary()); err == nil {
+        t.Fatal("first runRecord() succeeded, want the SRV error")
+    }
+    if rec.RecordID == "" {
+        t.Fatal("record_id not kept after the rollback failed")
+    }
+
+    delete(fake.errs, "create SRV")
+    changed, err := runRecord(context.Background(), api, config, rec, false, newRunSummary())
+    if err != nil {
+        t.Fatalf("second runRecord() error = %v", err)
+    }
+    if !changed {
+        t.Error("second runRecord() reported no change")
+    }
+    srv := fake.byType("SRV")
+    if len(srv) != 1 || rec.SRVRecordID != srv[0].ID {
+        t.Errorf("SRV records = %+v, srv_record_id = %q, want one SRV record with its ID saved", srv, rec.SRVRecordID)
+    }
+    if got := len(fake.byType("A")); got != 1 {
+        t.Errorf("%d A records, want the kept one only", got)
+    }
+}
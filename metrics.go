@@ -0,0 +1,121 @@
+package main
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "fmt"
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/promauto"
+    "github.com/prometheus/client_golang/prometheus/promhttp"
+    "log/slog"
+    "net/http"
+    "os"
+    "time"
+)
+
+var metricsAddr string
+
+var (
+    ipChecksTotal = promauto.NewCounter(prometheus.CounterOpts{
+        Name: "gddns_ip_checks_total",
+        Help: "Number of public IP lookups performed.",
+    })
+    updatesTotal = promauto.NewCounter(prometheus.CounterOpts{
+        Name: "gddns_updates_total",
+        Help: "Number of DNS records successfully created or updated.",
+    })
+    errorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+        Name: "gddns_errors_total",
+        Help: "Number of errors, by type.",
+    }, []string{"type"})
+    lastUpdateTimestamp = promauto.NewGauge(prometheus.GaugeOpts{
+        Name: "gddns_last_update_timestamp",
+        Help: "Unix time of the last successful DNS record update.",
+    })
+    currentIPInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+        Name: "gddns_current_ip_info",
+        Help: "The current public IP per address family, always 1.",
+    }, []string{"type", "ip"})
+    ipProviderDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+        Name:    "gddns_ip_provider_duration_seconds",
+        Help:    "Duration of the requests to each IP provider.",
+        Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+    }, []string{"provider"})
+    ipProviderRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+        Name: "gddns_ip_provider_requests_total",
+        Help: "Number of requests to each IP provider, by result (success or failure).",
+    }, []string{"provider", "result"})
+)
+
+// serveMetrics exposes /metrics and /healthz on addr in the background, over
+// HTTPS when tlsConfig is set.
+func serveMetrics(addr string, interval time.Duration, tlsConfig *tls.Config) {
+    mux := http.NewServeMux()
+    mux.Handle("/metrics", promhttp.Handler())
+    mux.Handle("/healthz", healthHandler(interval))
+    server := &http.Server{Addr: addr, Handler: mux, TLSConfig: tlsConfig}
+
+    go func() {
+        var err error
+        if tlsConfig != nil {
+            slog.Info("serving metrics", "addr", addr, "tls", true, "client_auth", tlsConfig.ClientCAs != nil)
+            // The certificate is already loaded into tlsConfig
+            err = server.ListenAndServeTLS("", "")
+        } else {
+            slog.Info("serving metrics", "addr", addr)
+            err = server.ListenAndServe()
+        }
+        logError("metrics server stopped", err, "addr", addr)
+    }()
+}
+
+// metricsTLS returns the TLS config of the metrics server, nil to serve plain
+// HTTP when metrics_tls_cert is not set. With metrics_tls_client_ca only
+// clients with a certificate signed by that CA get an answer.
+func (c *Config) metricsTLS() (*tls.Config, error) {
+    if c.MetricsTLSCert == "" {
+        return nil, nil
+    }
+
+    cert, err := tls.LoadX509KeyPair(c.relativePath(c.MetricsTLSCert), c.relativePath(c.MetricsTLSKey))
+    if err != nil {
+        return nil, fmt.Errorf("loading metrics_tls_cert and metrics_tls_key: %w", err)
+    }
+    tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+    if c.MetricsTLSClientCA == "" {
+        return tlsConfig, nil
+    }
+
+    pem, err := os.ReadFile(c.relativePath(c.MetricsTLSClientCA))
+    if err != nil {
+        return nil, fmt.Errorf("loading metrics_tls_client_ca: %w", err)
+    }
+    pool := x509.NewCertPool()
+    if !pool.AppendCertsFromPEM(pem) {
+        return nil, fmt.Errorf("metrics_tls_client_ca %s holds no PEM certificates", c.MetricsTLSClientCA)
+    }
+    tlsConfig.ClientCAs = pool
+    tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+    return tlsConfig, nil
+}
+
+func recordUpdateMetrics() {
+    updatesTotal.Inc()
+    lastUpdateTimestamp.Set(float64(time.Now().Unix()))
+}
+
+// observeIPProvider records one request to the IP provider name. A response
+// that is not a usable address counts as a failure.
+func observeIPProvider(name string, start time.Time, err error) {
+    ipProviderDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+    result := "success"
+    if err != nil {
+        result = "failure"
+    }
+    ipProviderRequestsTotal.WithLabelValues(name, result).Inc()
+}
+
+func setCurrentIPMetric(recordType, ip string) {
+    currentIPInfo.DeletePartialMatch(prometheus.Labels{"type": recordType})
+    currentIPInfo.WithLabelValues(recordType, ip).Set(1)
+}
@@ -0,0 +1,32 @@
+package main
+
+import "fmt"
+
+// currentConfigVersion is the config schema written by saveConfig. Files
+// without a version field are version 0.
+const currentConfigVersion = 1
+
+// configMigrations[v] upgrades a version v config to version v+1.
+var configMigrations = []func(*CfgFile){
+    // v0 -> v1: single record at the top level -> records array
+    (*CfgFile).migrateLegacyRecord,
+}
+
+// migrate upgrades c to currentConfigVersion and reports whether anything
+// had to be done. Configs from a newer gddns are rejected rather than
+// risking losing fields this version does not know about on the next save.
+func (c *CfgFile) migrate() (bool, error) {
+    if c.Version > currentConfigVersion {
+        return false, fmt.Errorf("config version %d is newer than the supported version %d, upgrade gddns", c.Version, currentConfigVersion)
+    }
+    if c.Version < 0 {
+        return false, fmt.Errorf("invalid config version %d", c.Version)
+    }
+
+    migrated := c.Version < currentConfigVersion
+    for c.Version < currentConfigVersion {
+        configMigrations[c.Version](c)
+        c.Version++
+    }
+    return migrated, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+    "encoding/json"
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+)
+
+// TestMigrateConfigFiles loads each config in testdata/migrate, which
+// migrates and rewrites it, and compares the file with the .want.json next
+// to it. A config without one is current and must be left as it is.
+func TestMigrateConfigFiles(t *testing.T) {
+    tests := []struct {
+        file       string
+        wantLastIP map[string]string
+    }{
+        {file: "v0-single.json", wantLastIP: map[string]string{"A": "192.0.2.1"}},
+        {file: "v0-both-srv.json", wantLastIP: map[string]string{"A": "192.0.2.1", "AAAA": "2001:db8::1"}},
+        {file: "v0-records.json", wantLastIP: map[string]string{"A": "192.0.2.1"}},
+        {file: "v1.json"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.file, func(t *testing.T) {
+            original, err := os.ReadFile(filepath.Join("testdata", "migrate", tt.file))
+            if err != nil {
+                t.Fatal(err)
+            }
+            want := original
+            wantFile := filepath.Join("testdata", "migrate", tt.file[:len(tt.file)-len(".json")]+".want.json")
+            if data, err := os.ReadFile(wantFile); err == nil {
+                want = data
+            }
+
+            dir := t.TempDir()
+            path := filepath.Join(dir, "config.json")
+            if err := os.WriteFile(path, original, 0600); err != nil {
+                t.Fatal(err)
+            }
+            t.Setenv("CF_API_TOKEN", "token")
+            t.Setenv("ZONE_ID", "023e105f4ecef8ad9ca31a8372d0c353")
+            configFile = path
+            t.Cleanup(func() { configFile = "" })
+            stateMu.Lock()
+            state = nil
+            stateMu.Unlock()
+
+            config, err := loadConfigAndEnv(path)
+            if err != nil {
+                t.Fatalf("loadConfigAndEnv() error = %v", err)
+            }
+            if config.Version != currentConfigVersion {
+                t.Errorf("version = %d, want %d", config.Version, currentConfigVersion)
+            }
+
+            got, err := os.ReadFile(path)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if !sameJSON(t, got, want) {
+                t.Errorf("rewritten config:\n%s\nwant:\n%s", got, want)
+            }
+
+            rec := config.Records[0]
+            for recordType, ip := range tt.wantLastIP {
+                if got := rec.lastIP(recordType); got != ip {
+                    t.Errorf("%s last IP in the state = %q, want %q", recordType, got, ip)
+                }
+            }
+
+            // Loading the migrated file again changes nothing
+            if _, err := loadConfigAndEnv(path); err != nil {
+                t.Fatalf("loading the migrated config: %v", err)
+            }
+            again, err := os.ReadFile(path)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if string(again) != string(got) {
+                t.Errorf("migrated config rewritten on the next load:\n%s", again)
+            }
+        })
+    }
+}
+
+// An old config on stdin is migrated in memory only, nothing is written to
+// stdout before the command's own output.
+func TestMigrateStdinConfig(t *testing.T) {
+    stdin, err := os.Open(filepath.Join("testdata", "migrate", "v0-single.json"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer stdin.Close()
+    stdout, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer stdout.Close()
+    oldStdin, oldStdout := os.Stdin, os.Stdout
+    os.Stdin, os.Stdout = stdin, stdout
+    t.Cleanup(func() { os.Stdin, os.Stdout = oldStdin, oldStdout })
+    t.Setenv("CF_API_TOKEN", "token")
+    t.Setenv("GDDNS_DATA_PATH", t.TempDir())
+    configFile = stdinConfig
+    t.Cleanup(func() { configFile = "" })
+
+    config, err := loadConfigAndEnv(stdinConfig)
+    if err != nil {
+        t.Fatalf("loadConfigAndEnv() error = %v", err)
+    }
+    if config.Version != currentConfigVersion || len(config.Records) != 1 {
+        t.Errorf("config = version %d with %d records, want it migrated", config.Version, len(config.Records))
+    }
+    info, err := stdout.Stat()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if info.Size() != 0 {
+        t.Errorf("loading wrote %d bytes to stdout, want none", info.Size())
+    }
+}
+
+func TestMigrateNewerVersion(t *testing.T) {
+    c := &CfgFile{Version: currentConfigVersion + 1}
+    if _, err := c.migrate(); err == nil {
+        t.Fatal("migrate() accepted a config from a newer version")
+    }
+}
+
+// sameJSON reports whether a and b hold the same JSON value, whatever their
+// formatting.
+func sameJSON(t *testing.T, a, b []byte) bool {
+    t.Helper()
+    var va, vb interface{}
+    if err := json.Unmarshal(a, &va); err != nil {
+        t.Fatalf("parsing %s: %v", a, err)
+    }
+    if err := json.Unmarshal(b, &vb); err != nil {
+        t.Fatalf("parsing %s: %v", b, err)
+    }
+    return reflect.DeepEqual(va, vb)
+}
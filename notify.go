@@ -0,0 +1,128 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "text/template"
+    "time"
+)
+
+// ipChange is the payload sent to notify_webhook, and the data available to
+// notify_template.
+type ipChange struct {
+    OldIP     string `json:"old_ip"`
+    NewIP     string `json:"new_ip"`
+    Record    string `json:"record"`
+    Timestamp string `json:"timestamp"`
+}
+
+// notifyIPChange POSTs change to the configured webhook. It is best effort:
+// failures are logged and never fail the update.
+func notifyIPChange(ctx context.Context, config *Config, change ipChange) {
+    if config.NotifyWebhook == "" {
+        return
+    }
+
+    if _, err := sendNotification(ctx, config, change); err != nil {
+        logError("error sending IP change notification", err, "record", change.Record)
+    }
+}
+
+// sendNotification POSTs change to notify_webhook and returns the HTTP
+// status of the response.
+func sendNotification(ctx context.Context, config *Config, change ipChange) (int, error) {
+    body, contentType, err := notificationBody(config, change)
+    if err != nil {
+        return 0, err
+    }
+
+    ctx, cancel := context.WithTimeout(ctx, config.requestTimeout())
+    defer cancel()
+
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.NotifyWebhook, bytes.NewReader(body))
+    if err != nil {
+        return 0, err
+    }
+    req.Header.Set("Content-Type", contentType)
+
+    client := &http.Client{Transport: config.httpTransport()}
+    resp, err := client.Do(req)
+    if err != nil {
+        return 0, err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return resp.StatusCode, fmt.Errorf("webhook returned HTTP %d", resp.StatusCode)
+    }
+    return resp.StatusCode, nil
+}
+
+// testNotifyCommand implements "gddns test-notify", which sends a made up IP
+// change to notify_webhook so the notification setup can be checked without
+// waiting for a real change.
+func testNotifyCommand(args []string) error {
+    fs := newFlagSet("test-notify")
+    if err := start(fs, args); err != nil {
+        return err
+    }
+
+    config, err := loadConfigAndEnv(configPath())
+    if err != nil {
+        return configError(fmt.Errorf("loading configuration: %w", err))
+    }
+    if config.NotifyWebhook == "" {
+        return configError(errors.New("notify_webhook is not set"))
+    }
+
+    rec := &Record{CNAME: "test", Domain: "example.com"}
+    if len(config.Records) > 0 {
+        rec = config.Records[0]
+    }
+    ctx, stop := signalContext()
+    defer stop()
+
+    status, err := sendNotification(ctx, config, newIPChange(rec, "0.0.0.0", "1.2.3.4"))
+    if err != nil {
+        return networkError(fmt.Errorf("sending test notification: %w", err))
+    }
+    fmt.Printf("Test notification sent to %s, webhook returned HTTP %d\n", redactURL(config.NotifyWebhook), status)
+    return nil
+}
+
+// notificationBody renders notify_template when set, and the JSON payload
+// otherwise.
+func notificationBody(config *Config, change ipChange) ([]byte, string, error) {
+    if config.NotifyTemplate == "" {
+        body, err := json.Marshal(change)
+        return body, "application/json", err
+    }
+
+    tmpl, err := template.New("notify_template").Parse(config.NotifyTemplate)
+    if err != nil {
+        return nil, "", err
+    }
+    var buf bytes.Buffer
+    if err := tmpl.Execute(&buf, change); err != nil {
+        return nil, "", err
+    }
+
+    contentType := "text/plain; charset=utf-8"
+    if json.Valid(buf.Bytes()) {
+        contentType = "application/json"
+    }
+    return buf.Bytes(), contentType, nil
+}
+
+func newIPChange(rec *Record, oldIP, newIP string) ipChange {
+    return ipChange{
+        OldIP:     oldIP,
+        NewIP:     newIP,
+        Record:    rec.fqdn(),
+        Timestamp: time.Now().UTC().Format(time.RFC3339),
+    }
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "log/slog"
+    "os"
+    "strconv"
+    "strings"
+    "syscall"
+)
+
+// writePIDFile writes the PID of gddns to path for init systems and
+// monitoring. It refuses to overwrite the file of a process that is still
+// running; a stale file is replaced. The returned function removes the file.
+func writePIDFile(path string) (func(), error) {
+    if data, err := os.ReadFile(path); err == nil {
+        pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+        if err == nil && pid > 0 && pid != os.Getpid() && processAlive(pid) {
+            return nil, fmt.Errorf("pid file %s belongs to running process %d", path, pid)
+        }
+        slog.Warn("replacing stale pid file", "path", path, "pid", strings.TrimSpace(string(data)))
+    } else if !errors.Is(err, os.ErrNotExist) {
+        return nil, fmt.Errorf("reading pid file: %w", err)
+    }
+
+    if err := os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+        return nil, fmt.Errorf("writing pid file: %w", err)
+    }
+    return func() {
+        if err := os.Remove(path); err != nil {
+            logError("error removing pid file", err, "path", path)
+        }
+    }, nil
+}
+
+// processAlive reports whether a process with the given PID exists. EPERM
+// means it exists but belongs to another user.
+func processAlive(pid int) bool {
+    err := syscall.Kill(pid, 0)
+    return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "os"
+)
+
+// preflight runs the read-only checks of a dry run and prints them as a
+// checklist: the API tokens, the zones and every managed record. Nothing is
+// changed, a zone ID it discovers is not saved. It fails if any check did.
+func preflight(ctx context.Context, clients *clientSet, config *Config) error {
+    checks, failed := 0, 0
+    check := func(err error, format string, args ...any) bool {
+        checks++
+        line := fmt.Sprintf(format, args...)
+        if err != nil {
+            failed++
+            fmt.Fprintf(os.Stdout, "[FAIL] %s: %v\n", line, err)
+            return false
+        }
+        fmt.Fprintf(os.Stdout, "[ok]   %s\n", line)
+        return true
+    }
+
+    verified := make(map[string]bool)
+    for _, rec := range config.Records {
+        api, err := clients.forRecord(rec)
+        if !check(err, "credentials for %s", rec.fqdn()) {
+            continue
+        }
+        if !verified[rec.Credentials] {
+            verified[rec.Credentials] = true
+            if isToken, err := clients.verifyToken(ctx, rec); isToken {
+                check(err, "API token%s is active", credentialsLabel(rec.Credentials))
+            }
+        }
+
+        if rec.ZoneID == "" {
+            err := resolveZoneID(ctx, api, config, rec)
+            if !check(err, "zone %s found", rec.Domain) {
+                continue
+            }
+        } else if !check(checkZone(ctx, api, config, rec), "%s is within zone %s", rec.fqdn(), rec.ZoneID) {
+            continue
+        }
+
+        for _, recordType := range rec.recordTypes() {
+            records, err := findRecord(ctx, api, config, rec, recordType)
+            if err != nil {
+                check(apiError(err), "%s record %s readable in zone %s", recordType, rec.fqdn(), rec.ZoneID)
+                continue
+            }
+
+            id := rec.recordID(recordType)
+            switch {
+            case len(records) == 0:
+                check(nil, "%s record %s does not exist yet and would be created", recordType, rec.fqdn())
+            case id == "" && len(records) > 1:
+                check(fmt.Errorf("%d records share the name, set the record ID in the config", len(records)), "%s record %s", recordType, rec.fqdn())
+            case id == "":
+                check(nil, "%s record %s exists (%s, currently %s) and would be adopted", recordType, rec.fqdn(), records[0].ID, records[0].Content)
+            default:
+                err := fmt.Errorf("no record with the configured ID %s", id)
+                for _, record := range records {
+                    if record.ID == id {
+                        err = nil
+                        check(nil, "%s record %s exists (%s, currently %s)", recordType, rec.fqdn(), id, record.Content)
+                    }
+                }
+                if err != nil {
+                    check(err, "%s record %s", recordType, rec.fqdn())
+                }
+            }
+        }
+    }
+
+    if failed > 0 {
+        return apiError(fmt.Errorf("%d of %d dry run checks failed", failed, checks))
+    }
+    return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+    "context"
+    "golang.org/x/time/rate"
+    "time"
+)
+
+// DNSProvider is what gddns needs from a DNS provider, in terms that do not
+// depend on any one of them. Cloudflare and DigitalOcean implement it; tests
+// and wrappers can provide their own.
+type DNSProvider interface {
+    // FindRecord returns the records of the zone with the given type and
+    // full name. An empty type or name matches any.
+    FindRecord(ctx context.Context, zoneID, recordType, name string) ([]dnsRecord, error)
+    GetRecord(ctx context.Context, zoneID, recordID string) (dnsRecord, error)
+    // CreateRecord creates record, whose ID is ignored, and returns it with
+    // the ID the provider gave it.
+    CreateRecord(ctx context.Context, zoneID string, record dnsRecord) (dnsRecord, error)
+    // UpdateRecord overwrites the record with the ID of record.
+    UpdateRecord(ctx context.Context, zoneID string, record dnsRecord) error
+    DeleteRecord(ctx context.Context, zoneID, recordID string) error
+
+    // FindZones returns the zones named name the credentials can see. There
+    // can be several, in different accounts.
+    FindZones(ctx context.Context, name string) ([]dnsZone, error)
+    GetZone(ctx context.Context, zoneID string) (dnsZone, error)
+
+    // VerifyToken checks the API token the provider was created with.
+    VerifyToken(ctx context.Context) (tokenStatus, error)
+}
+
+// dnsRecord is one DNS record. SRV records carry Data instead of Content.
+// Providers without proxying, comments or tags leave those empty. The JSON
+// names match Cloudflare's, which record backups were first written with.
+type dnsRecord struct {
+    ID       string      `json:"id"`
+    Type     string      `json:"type"`
+    Name     string      `json:"name"`
+    Content  string      `json:"content"`
+    Data     interface{} `json:"data,omitempty"`
+    Priority *uint16     `json:"priority,omitempty"`
+    TTL      int         `json:"ttl"`
+    Proxied  bool        `json:"proxied"`
+    Comment  string      `json:"comment"`
+    Tags     []string    `json:"tags,omitempty"`
+}
+
+// dnsZone is a zone and, where the provider has several per login, the
+// account it belongs to.
+type dnsZone struct {
+    ID      string
+    Name    string
+    Account string
+}
+
+// tokenStatus is what VerifyToken found out about an API token. Status is
+// "active" for a usable token.
+type tokenStatus struct {
+    ID        string
+    Status    string
+    ExpiresOn time.Time
+}
+
+// notFoundError marks a provider error saying the zone, or the record within
+// it, does not exist. The two call for different fixes, see zoneNotFound and
+// recordNotFound.
+type notFoundError struct {
+    zone bool
+    err  error
+}
+
+func (e *notFoundError) Error() string {
+    return e.err.Error()
+}
+
+func (e *notFoundError) Unwrap() error {
+    return e.err
+}
+
+// defaultRateLimit keeps well under Cloudflare's limit of 1200 requests per
+// five minutes, which averages to 4 requests per second.
+const (
+    defaultRateLimit = 2.0
+    rateLimitBurst   = 5
+)
+
+func (c *Config) rateLimit() rate.Limit {
+    if c.RateLimit > 0 {
+        return rate.Limit(c.RateLimit)
+    }
+    return rate.Limit(defaultRateLimit)
+}
+
+// rateLimitedProvider waits for the limiter before every call to the wrapped
+// provider. A wait that would overrun the context deadline fails right away.
+type rateLimitedProvider struct {
+    provider DNSProvider
+    limiter  *rate.Limiter
+}
+
+func newRateLimitedProvider(provider DNSProvider, limit rate.Limit) *rateLimitedProvider {
+    return &rateLimitedProvider{provider: provider, limiter: rate.NewLimiter(limit, rateLimitBurst)}
+}
+
+func (p *rateLimitedProvider) FindRecord(ctx context.Context, zoneID, recordType, name string) ([]dnsRecord, error) {
+    if err := p.limiter.Wait(ctx); err != nil {
+        return nil, err
+    }
+    return p.provider.FindRecord(ctx, zoneID, recordType, name)
+}
+
+func (p *rateLimitedProvider) GetRecord(ctx context.Context, zoneID, recordID string) (dnsRecord, error) {
+    if err := p.limiter.Wait(ctx); err != nil {
+        return dnsRecord{}, err
+    }
+    return p.provider.GetRecord(ctx, zoneID, recordID)
+}
+
+func (p *rateLimitedProvider) CreateRecord(ctx context.Context, zoneID string, record dnsRecord) (dnsRecord, error) {
+    if err := p.limiter.Wait(ctx); err != nil {
+        return dnsRecord{}, err
+    }
+    return p.provider.CreateRecord(ctx, zoneID, record)
+}
+
+func (p *rateLimitedProvider) UpdateRecord(ctx context.Context, zoneID string, record dnsRecord) error {
+    if err := p.limiter.Wait(ctx); err != nil {
+        return err
+    }
+    return p.provider.UpdateRecord(ctx, zoneID, record)
+}
+
+func (p *rateLimitedProvider) DeleteRecord(ctx context.Context, zoneID, recordID string) error {
+    if err := p.limiter.Wait(ctx); err != nil {
+        return err
+    }
+    return p.provider.DeleteRecord(ctx, zoneID, recordID)
+}
+
+func (p *rateLimitedProvider) FindZones(ctx context.Context, name string) ([]dnsZone, error) {
+    if err := p.limiter.Wait(ctx); err != nil {
+        return nil, err
+    }
+    return p.provider.FindZones(ctx, name)
+}
+
+func (p *rateLimitedProvider) GetZone(ctx context.Context, zoneID string) (dnsZone, error) {
+    if err := p.limiter.Wait(ctx); err != nil {
+        return dnsZone{}, err
+    }
+    return p.provider.GetZone(ctx, zoneID)
+}
+
+func (p *rateLimitedProvider) VerifyToken(ctx context.Context) (tokenStatus, error) {
+    if err := p.limiter.Wait(ctx); err != nil {
+        return tokenStatus{}, err
+    }
+    return p.provider.VerifyToken(ctx)
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log/slog"
+    "os"
+    "strings"
+    "text/template"
+    "time"
+)
+
+// StaticRecord is an extra record with fixed content managed next to the
+// address records, e.g. a TXT verification string or an MX record. Name is
+// relative to the managed host, empty means the host itself.
+type StaticRecord struct {
+    Type     string  `json:"type"`
+    Name     string  `json:"name,omitempty"`
+    Content  string  `json:"content"`
+    Priority *uint16 `json:"priority,omitempty"`
+    TTL      TTL     `json:"ttl,omitempty"`
+    Proxied  *bool   `json:"proxied,omitempty"`
+    ID       string  `json:"id,omitempty"`
+}
+
+func (s *StaticRecord) validate() error {
+    switch s.Type {
+    case "CNAME", "TXT", "MX":
+    default:
+        return fmt.Errorf("unsupported static record type %q, expected \"CNAME\", \"TXT\" or \"MX\"", s.Type)
+    }
+    if s.Name != "" && !validName(s.Name) {
+        return fmt.Errorf("%s record name %q is not a valid DNS name", s.Type, s.Name)
+    }
+    if s.Type == "CNAME" && s.Name == "" {
+        return errors.New("CNAME record needs a name, it cannot share the host of the address records")
+    }
+    if s.Content == "" {
+        return fmt.Errorf("%s record content must be set", s.Type)
+    }
+    if s.Type == "MX" && s.Priority == nil {
+        return errors.New("MX record priority must be set")
+    }
+    if !validTTL(s.TTL) {
+        return fmt.Errorf("invalid %s record ttl %d, expected \"auto\" or a value within 60-86400", s.Type, s.TTL)
+    }
+    if s.Proxied != nil && *s.Proxied && s.Type != "CNAME" {
+        return fmt.Errorf("%s records cannot be proxied", s.Type)
+    }
+
+    return nil
+}
+
+// recordSpec is everything needed to create or update one DNS record. SRV
+// records carry Data instead of Content.
+type recordSpec struct {
+    Type     string
+    Name     string
+    Content  string
+    Data     interface{}
+    Priority *uint16
+    TTL      int
+    Proxied  bool
+    Tags     []string
+}
+
+var defaultTags = []string{"gddns"}
+
+// tags returns the tags set on managed records.
+func (c *Config) tags() []string {
+    if c.Tags != nil {
+        return *c.Tags
+    }
+    return defaultTags
+}
+
+// addressSpec is the A or AAAA record of rec, pointing at the current IP, or
+// its CNAME record.
+func addressSpec(config *Config, rec *Record, recordType string) recordSpec {
+    return recordSpec{
+        Type:    recordType,
+        Name:    rec.fqdn(),
+        Content: config.content(rec, recordType),
+        TTL:     config.recordTTL(rec),
+        Proxied: config.recordProxied(rec),
+        Tags:    config.tags(),
+    }
+}
+
+func srvSpec(config *Config, rec *Record) recordSpec {
+    // SRV records cannot be proxied, Proxied stays false
+    return recordSpec{
+        Type: "SRV",
+        Name: rec.fqdn(),
+        Data: rec.srv().data(rec.fqdn()),
+        TTL:  config.srvTTL(rec),
+        Tags: config.tags(),
+    }
+}
+
+func staticSpec(config *Config, rec *Record, static *StaticRecord) recordSpec {
+    name := rec.fqdn()
+    if static.Name != "" {
+        name = static.Name + "." + name
+    }
+    ttl := int(static.TTL)
+    if ttl == 0 {
+        ttl = config.recordTTL(rec)
+    }
+
+    return recordSpec{
+        Type:     static.Type,
+        Name:     name,
+        Content:  static.Content,
+        Priority: static.Priority,
+        TTL:      ttl,
+        Proxied:  static.Proxied != nil && *static.Proxied,
+        Tags:     config.tags(),
+    }
+}
+
+// proxiable reports whether Cloudflare can proxy records of recordType. It
+// rejects proxied SRV, TXT and MX records, whatever the global proxied says.
+func proxiable(recordType string) bool {
+    switch recordType {
+    case "A", "AAAA", "CNAME":
+        return true
+    }
+    return false
+}
+
+// proxied is the proxied flag sent to Cloudflare for spec.
+func (s recordSpec) proxied() bool {
+    return s.Proxied && proxiable(s.Type)
+}
+
+// content returns the record content for logging, SRV data included.
+func (s recordSpec) content() string {
+    if s.Data != nil {
+        return fmt.Sprint(s.Data)
+    }
+    return s.Content
+}
+
+// record is the record spec describes, with the given ID and comment.
+func (s recordSpec) record(id, comment string) dnsRecord {
+    return dnsRecord{
+        ID:       id,
+        Type:     s.Type,
+        Name:     s.Name,
+        Content:  s.Content,
+        Data:     s.Data,
+        Priority: s.Priority,
+        TTL:      s.TTL,
+        Proxied:  s.proxied(),
+        Comment:  comment,
+        Tags:     s.Tags,
+    }
+}
+
+// createDNSRecord creates the record described by spec in rec's zone and
+// returns its ID.
+func createDNSRecord(ctx context.Context, api DNSProvider, config *Config, rec *Record, spec recordSpec) (string, error) {
+    comment, err := recordComment(config, spec)
+    if err != nil {
+        return "", err
+    }
+    slog.Debug("creating DNS record", "zone_id", rec.ZoneID, "spec", spec)
+
+    var record dnsRecord
+    err = withRetry(ctx, config, "create DNS record", func(ctx context.Context) error {
+        var err error
+        record, err = api.CreateRecord(ctx, rec.ZoneID, spec.record("", comment))
+        return err
+    })
+    return record.ID, err
+}
+
+// createPreview is what --preview-create prints for a record gddns would
+// create: the exact request, comment and tags included.
+type createPreview struct {
+    Record   string      `json:"record"`
+    ZoneID   string      `json:"zone_id"`
+    Type     string      `json:"type"`
+    Name     string      `json:"name"`
+    Content  string      `json:"content,omitempty"`
+    Data     interface{} `json:"data,omitempty"`
+    Priority *uint16     `json:"priority,omitempty"`
+    TTL      TTL         `json:"ttl"`
+    Proxied  bool        `json:"proxied"`
+    Comment  string      `json:"comment,omitempty"`
+    Tags     []string    `json:"tags,omitempty"`
+}
+
+// printCreatePreview writes the record createDNSRecord would create for spec
+// to stdout instead of creating it.
+func printCreatePreview(config *Config, rec *Record, spec recordSpec) error {
+    comment, err := recordComment(config, spec)
+    if err != nil {
+        return err
+    }
+    preview := createPreview{
+        Record:   rec.CNAME,
+        ZoneID:   rec.ZoneID,
+        Type:     spec.Type,
+        Name:     spec.Name,
+        Content:  spec.Content,
+        Data:     spec.Data,
+        Priority: spec.Priority,
+        TTL:      TTL(spec.TTL),
+        Proxied:  spec.proxied(),
+        Comment:  comment,
+        Tags:     spec.Tags,
+    }
+
+    enc := json.NewEncoder(os.Stdout)
+    enc.SetEscapeHTML(false)
+    enc.SetIndent("", "  ")
+    return enc.Encode(preview)
+}
+
+// updateDNSRecord overwrites the record with the given ID with spec.
+func updateDNSRecord(ctx context.Context, api DNSProvider, config *Config, rec *Record, spec recordSpec, id string) error {
+    comment, err := recordComment(config, spec)
+    if err != nil {
+        return err
+    }
+    slog.Debug("updating DNS record", "zone_id", rec.ZoneID, "record_id", id, "spec", spec)
+
+    return withRetry(ctx, config, "update DNS record", func(ctx context.Context) error {
+        return api.UpdateRecord(ctx, rec.ZoneID, spec.record(id, comment))
+    })
+}
+
+// syncStaticRecords creates the static records of rec that have no ID yet and
+// rewrites the others when they no longer match the config, so edits to the
+// config are picked up on the next run. A record deleted outside of gddns is
+// created again. It reports whether an ID changed; the caller is responsible
+// for saving the config.
+func syncStaticRecords(ctx context.Context, api DNSProvider, config *Config, rec *Record) (bool, error) {
+    changed := false
+    for _, static := range rec.Static {
+        spec := staticSpec(config, rec, static)
+        if static.ID != "" {
+            current, err := getStaticRecord(ctx, api, config, rec, static.ID)
+            if err == nil && staticCurrent(current, spec) {
+                slog.Debug("static DNS record already current", "record", rec.CNAME, "type", spec.Type, "name", spec.Name, "record_id", static.ID)
+                continue
+            }
+            if recordNotFound(err) {
+                slog.Warn("static record no longer exists, recreating it", "record", rec.CNAME, "type", spec.Type,
+                    "name", spec.Name, "record_id", static.ID)
+                if !dryRun {
+                    static.ID = ""
+                    changed = true
+                }
+            } else if err != nil {
+                slog.Warn("could not read the static record, updating anyway", "record", rec.CNAME, "type", spec.Type,
+                    "name", spec.Name, "error", err)
+            }
+        }
+
+        action := "update"
+        if static.ID == "" {
+            action = "create"
+        }
+        if dryRun {
+            printDryRun(action, spec)
+            continue
+        }
+        if previewCreate && static.ID == "" {
+            if err := printCreatePreview(config, rec, spec); err != nil {
+                return changed, err
+            }
+            continue
+        }
+
+        start := time.Now()
+        if static.ID != "" {
+            if err := updateDNSRecord(ctx, api, config, rec, spec, static.ID); err != nil {
+                return changed, fmt.Errorf("updating %s record %s: %w", spec.Type, spec.Name, err)
+            }
+            logEvent("record_updated", "DNS record updated", "record", rec.CNAME, "type", spec.Type, "name", spec.Name,
+                "record_id", static.ID, "zone_id", rec.ZoneID, "duration_ms", durationMS(start))
+            continue
+        }
+
+        id, err := createDNSRecord(ctx, api, config, rec, spec)
+        if err != nil {
+            return changed, fmt.Errorf("creating %s record %s: %w", spec.Type, spec.Name, err)
+        }
+        static.ID = id
+        changed = true
+        logEvent("record_created", "DNS record created", "record", rec.CNAME, "type", spec.Type, "name", spec.Name,
+            "record_id", id, "zone_id", rec.ZoneID, "duration_ms", durationMS(start))
+    }
+
+    return changed, nil
+}
+
+func getStaticRecord(ctx context.Context, api DNSProvider, config *Config, rec *Record, id string) (dnsRecord, error) {
+    var record dnsRecord
+    err := withRetry(ctx, config, "get DNS record", func(ctx context.Context) error {
+        var err error
+        record, err = api.GetRecord(ctx, rec.ZoneID, id)
+        return err
+    })
+    return record, err
+}
+
+// staticCurrent reports whether the deployed record already has the type,
+// content, TTL, proxied flag and priority of spec. Target names compare
+// without their trailing dot and TXT content without its quotes, which
+// providers add or drop as they like.
+func staticCurrent(current dnsRecord, spec recordSpec) bool {
+    if current.Type != spec.Type || current.TTL != spec.TTL || current.Proxied != spec.proxied() {
+        return false
+    }
+    if (current.Priority == nil) != (spec.Priority == nil) || (current.Priority != nil && *current.Priority != *spec.Priority) {
+        return false
+    }
+    if spec.Type == "TXT" {
+        return strings.Trim(current.Content, `"`) == strings.Trim(spec.Content, `"`)
+    }
+    return strings.EqualFold(strings.TrimSuffix(current.Content, "."), strings.TrimSuffix(spec.Content, "."))
+}
+
+const defaultCommentTemplate = "gddns @ {{.Time}}"
+
+// commentData is what comment_template is rendered with.
+type commentData struct {
+    IP       string
+    Time     string
+    Hostname string
+}
+
+// recordComment renders comment_template for spec. An empty template leaves
+// the record without a comment.
+func recordComment(config *Config, spec recordSpec) (string, error) {
+    text := defaultCommentTemplate
+    if config.CommentTemplate != nil {
+        text = *config.CommentTemplate
+    }
+    if text == "" {
+        return "", nil
+    }
+
+    tmpl, err := template.New("comment_template").Parse(text)
+    if err != nil {
+        return "", fmt.Errorf("parsing comment_template: %w", err)
+    }
+    hostname, _ := os.Hostname()
+
+    var comment strings.Builder
+    data := commentData{IP: spec.Content, Time: time.Now().Format(time.RFC3339), Hostname: hostname}
+    if err := tmpl.Execute(&comment, data); err != nil {
+        return "", fmt.Errorf("rendering comment_template: %w", err)
+    }
+    return comment.String(), nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log/slog"
+    "math/rand"
+    "net"
+    "net/http"
+    "strconv"
+    "time"
+)
+
+const (
+    defaultRetryAttempts  = 3
+    defaultRetryBaseDelay = time.Second
+    maxRetryDelay         = 30 * time.Second
+)
+
+// transientError is returned for 429 and 5xx responses, which are worth
+// retrying. retryAfter holds the server's Retry-After hint, if any.
+type transientError struct {
+    status     int
+    retryAfter time.Duration
+}
+
+func (e *transientError) Error() string {
+    return fmt.Sprintf("received %s response (HTTP %d)", http.StatusText(e.status), e.status)
+}
+
+// transientTransport turns 429 and 5xx responses into transientErrors so
+// withRetry can see them and their Retry-After header.
+type transientTransport struct {
+    base http.RoundTripper
+}
+
+func (t *transientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    resp, err := t.base.RoundTrip(req)
+    if err != nil {
+        return nil, err
+    }
+
+    if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError {
+        resp.Body.Close()
+        return nil, &transientError{status: resp.StatusCode, retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+    }
+
+    return resp, nil
+}
+
+// parseRetryAfter accepts both the delay-seconds and HTTP-date forms.
+func parseRetryAfter(value string) time.Duration {
+    if value == "" {
+        return 0
+    }
+    if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+        return time.Duration(seconds) * time.Second
+    }
+    if date, err := http.ParseTime(value); err == nil {
+        return time.Until(date)
+    }
+    return 0
+}
+
+func (c *Config) retryAttempts() int {
+    if c.RetryAttempts <= 0 {
+        return defaultRetryAttempts
+    }
+    return c.RetryAttempts
+}
+
+func (c *Config) retryBaseDelay() time.Duration {
+    delay, err := time.ParseDuration(c.RetryBaseDelay)
+    if err != nil || delay <= 0 {
+        return defaultRetryBaseDelay
+    }
+    return delay
+}
+
+// withRetry runs fn until it succeeds, fails with a non-transient error or the
+// configured number of attempts is used up, backing off exponentially with
+// jitter in between. Each attempt gets its own request timeout.
+func withRetry(ctx context.Context, config *Config, op string, fn func(ctx context.Context) error) error {
+    attempts := config.retryAttempts()
+    for attempt := 1; ; attempt++ {
+        attemptCtx, cancel := context.WithTimeout(ctx, config.requestTimeout())
+        err := fn(attemptCtx)
+        cancel()
+        if err == nil || attempt >= attempts || !isTransient(ctx, err) {
+            return err
+        }
+
+        delay := backoff(config.retryBaseDelay(), attempt)
+        var transient *transientError
+        if errors.As(err, &transient) && transient.retryAfter > delay {
+            delay = transient.retryAfter
+        }
+
+        slog.Warn("retrying after error", "op", op, "attempt", attempt, "delay", delay, "error", err)
+        select {
+        case <-time.After(delay):
+        case <-ctx.Done():
+            return fmt.Errorf("%s: aborted during backoff: %w", op, ctx.Err())
+        }
+    }
+}
+
+// backoff returns base * 2^(attempt-1), capped at maxRetryDelay, plus up to
+// 50% random jitter.
+func backoff(base time.Duration, attempt int) time.Duration {
+    delay := base << (attempt - 1)
+    if delay > maxRetryDelay || delay <= 0 {
+        delay = maxRetryDelay
+    }
+    return delay + time.Duration(rand.Int63n(int64(delay)/2+1))
+}
+
+func isTransient(ctx context.Context, err error) bool {
+    if ctx.Err() != nil {
+        return false
+    }
+
+    var transient *transientError
+    if errors.As(err, &transient) {
+        return true
+    }
+    var netErr net.Error
+    return errors.As(err, &netErr)
+}
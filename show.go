@@ -0,0 +1,188 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/url"
+    "os"
+)
+
+const redacted = "<redacted>"
+
+// effectiveConfig is the config as gddns will actually use it, with every
+// default filled in and the credentials redacted.
+type effectiveConfig struct {
+    ConfigFile        string                   `json:"config_file"`
+    Version           int                      `json:"version"`
+    Auth              effectiveAuth            `json:"auth"`
+    Credentials       map[string]effectiveAuth `json:"credentials,omitempty"`
+    IPSource          string                   `json:"ip_source"`
+    IPProviders       []string                 `json:"ip_providers,omitempty"`
+    IPv6Suffix        string                   `json:"ipv6_suffix,omitempty"`
+    AllowPrivateIP    bool                     `json:"allow_private_ip"`
+    IPAllowlist       []string                 `json:"ip_allowlist,omitempty"`
+    IPDenylist        []string                 `json:"ip_denylist,omitempty"`
+    Interval          string                   `json:"interval,omitempty"`
+    Cron              string                   `json:"cron,omitempty"`
+    MinUpdateInterval string                   `json:"min_update_interval,omitempty"`
+    RequestTimeout    string                   `json:"request_timeout"`
+    RetryAttempts     int                      `json:"retry_attempts"`
+    RetryBaseDelay    string                   `json:"retry_base_delay"`
+    HTTPProxy         string                   `json:"http_proxy,omitempty"`
+    NotifyWebhook     string                   `json:"notify_webhook,omitempty"`
+    Tags              []string                 `json:"tags"`
+    Records           []effectiveRecord        `json:"records"`
+}
+
+type effectiveAuth struct {
+    Provider string `json:"provider"`
+    Method   string `json:"method"`
+    Email    string `json:"email,omitempty"`
+    Secret   string `json:"secret"`
+}
+
+type effectiveRecord struct {
+    Name        string            `json:"name"`
+    Credentials string            `json:"credentials,omitempty"`
+    ZoneID      string            `json:"zone_id"`
+    Types       []string          `json:"types"`
+    TTL         TTL               `json:"ttl"`
+    Proxied     bool              `json:"proxied"`
+    RecordIDs   map[string]string `json:"record_ids"`
+    SRV         *SRVConfig        `json:"srv,omitempty"`
+    SRVTTL      TTL               `json:"srv_ttl,omitempty"`
+    SRVRecordID string            `json:"srv_record_id,omitempty"`
+    Static      []*StaticRecord   `json:"static,omitempty"`
+}
+
+// configCommand implements "gddns config show".
+func configCommand(args []string) error {
+    if len(args) == 0 || args[0] != "show" {
+        return configError(errors.New("usage: gddns config show [flags]"))
+    }
+
+    fs := newFlagSet("config show")
+    if err := start(fs, args[1:]); err != nil {
+        return err
+    }
+
+    // Showing the config must never rewrite it, even if it needs migrating
+    dryRun = true
+    config, err := loadConfigAndEnv(configPath())
+    if err != nil {
+        return configError(fmt.Errorf("loading configuration: %w", err))
+    }
+    effective, err := resolveConfig(config)
+    if err != nil {
+        return configError(err)
+    }
+
+    enc := json.NewEncoder(os.Stdout)
+    enc.SetEscapeHTML(false)
+    enc.SetIndent("", "  ")
+    return enc.Encode(effective)
+}
+
+func resolveConfig(config *Config) (*effectiveConfig, error) {
+    interval, err := pollInterval(config)
+    if err != nil {
+        return nil, err
+    }
+
+    effective := &effectiveConfig{
+        ConfigFile:     configPath(),
+        Version:        config.Version,
+        IPSource:       "public",
+        AllowPrivateIP: config.AllowPrivateIP,
+        IPAllowlist:    config.IPAllowlist,
+        IPDenylist:     config.IPDenylist,
+        Interval:       interval.String(),
+        RequestTimeout: config.requestTimeout().String(),
+        RetryAttempts:  config.retryAttempts(),
+        RetryBaseDelay: config.retryBaseDelay().String(),
+        HTTPProxy:      redactURL(config.HTTPProxy),
+        NotifyWebhook:  redactURL(config.NotifyWebhook),
+        Tags:           config.tags(),
+    }
+
+    if config.Cron != "" && config.Env.Interval == "" {
+        effective.Interval, effective.Cron = "", config.Cron
+    }
+    if window := config.minUpdateInterval(); window > 0 {
+        effective.MinUpdateInterval = window.String()
+    }
+
+    clients := newClientSet(config)
+    envCreds, _ := clients.credentials("")
+    effective.Auth = redactCredentials(config.provider(""), envCreds)
+    for name, creds := range config.Credentials {
+        if effective.Credentials == nil {
+            effective.Credentials = make(map[string]effectiveAuth)
+        }
+        effective.Credentials[name] = redactCredentials(config.provider(name), creds)
+    }
+
+    if _, ok := interfaceName(config.IPSource); ok {
+        effective.IPSource = config.IPSource
+        effective.IPv6Suffix = config.IPv6Suffix
+    } else {
+        if config.IPSource == "upnp" {
+            effective.IPSource = config.IPSource
+        }
+        effective.IPProviders = config.IPProviders
+        if len(effective.IPProviders) == 0 {
+            effective.IPProviders = defaultIPProviders
+        }
+    }
+
+    for _, rec := range config.Records {
+        record := effectiveRecord{
+            Name:        rec.fqdn(),
+            Credentials: rec.Credentials,
+            ZoneID:      rec.ZoneID,
+            Types:       rec.recordTypes(),
+            TTL:         TTL(config.recordTTL(rec)),
+            Proxied:     config.recordProxied(rec),
+            RecordIDs:   make(map[string]string),
+            SRV:         rec.srv(),
+            SRVRecordID: rec.SRVRecordID,
+            Static:      rec.Static,
+        }
+        if record.SRV != nil {
+            record.SRVTTL = TTL(config.srvTTL(rec))
+        }
+        if record.ZoneID == "" {
+            record.ZoneID = "(discovered from the domain on the next update)"
+        }
+        for _, recordType := range record.Types {
+            record.RecordIDs[recordType] = rec.recordID(recordType)
+        }
+        effective.Records = append(effective.Records, record)
+    }
+
+    return effective, nil
+}
+
+func redactCredentials(provider string, creds Credentials) effectiveAuth {
+    if creds.APIToken != "" || provider == providerDigitalOcean {
+        return effectiveAuth{Provider: provider, Method: "api_token", Secret: redacted}
+    }
+    return effectiveAuth{Provider: provider, Method: "api_key", Email: creds.Email, Secret: redacted}
+}
+
+// redactURL hides the credentials and query of a webhook URL, which often
+// carry a token.
+func redactURL(raw string) string {
+    u, err := url.Parse(raw)
+    if err != nil || raw == "" {
+        return raw
+    }
+    if u.User != nil {
+        u.User = url.User(redacted)
+    }
+    if u.RawQuery != "" {
+        u.RawQuery = redacted
+    }
+    return u.String()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
+)
+
+// SRVConfig describes the optional SRV record created next to the address
+// record. Target defaults to the managed hostname when left empty.
+type SRVConfig struct {
+    Service  string `json:"service"`
+    Proto    string `json:"proto"`
+    Priority int    `json:"priority"`
+    Weight   int    `json:"weight"`
+    Port     int    `json:"port"`
+    Target   string `json:"target,omitempty"`
+}
+
+// srvPresets are the SRV settings of the services srv_preset can name.
+var srvPresets = map[string]SRVConfig{
+    "minecraft": {Service: "_minecraft", Proto: "_tcp", Port: 25565},
+    "teamspeak": {Service: "_ts3", Proto: "_udp", Port: 9987},
+    "mumble":    {Service: "_mumble", Proto: "_tcp", Port: 64738},
+}
+
+func srvPresetNames() []string {
+    names := make([]string, 0, len(srvPresets))
+    for name := range srvPresets {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// srv returns the SRV settings of r: its srv_preset with the settings of its
+// srv section on top, or nil when it has neither.
+func (r *Record) srv() *SRVConfig {
+    preset, ok := srvPresets[r.SRVPreset]
+    if !ok {
+        return r.SRV
+    }
+    if r.SRV == nil {
+        return &preset
+    }
+
+    merged := preset
+    override := r.SRV
+    if override.Service != "" {
+        merged.Service = override.Service
+    }
+    if override.Proto != "" {
+        merged.Proto = override.Proto
+    }
+    if override.Priority != 0 {
+        merged.Priority = override.Priority
+    }
+    if override.Weight != 0 {
+        merged.Weight = override.Weight
+    }
+    if override.Port != 0 {
+        merged.Port = override.Port
+    }
+    if override.Target != "" {
+        merged.Target = override.Target
+    }
+    return &merged
+}
+
+func (s *SRVConfig) validate() error {
+    if s.Service == "" {
+        return errors.New("srv.service must be set")
+    }
+    if s.Proto != "_tcp" && s.Proto != "_udp" {
+        return fmt.Errorf("srv.proto must be \"_tcp\" or \"_udp\", got %q", s.Proto)
+    }
+    if s.Port < 1 || s.Port > 65535 {
+        return fmt.Errorf("srv.port must be within 1-65535, got %d", s.Port)
+    }
+
+    return nil
+}
+
+// data builds the SRV Data map for the record named cnameFull.
+func (s *SRVConfig) data(cnameFull string) map[string]interface{} {
+    target := s.Target
+    if target == "" {
+        target = cnameFull
+    }
+
+    return map[string]interface{}{
+        "service":  s.Service,
+        "proto":    s.Proto,
+        "name":     cnameFull,
+        "priority": s.Priority,
+        "weight":   s.Weight,
+        "port":     s.Port,
+        "target":   target,
+    }
+}
+
+// changes lists the settings in which the deployed SRV record differs from
+// the config, e.g. ["port 25565 -> 25566"]. Cloudflare returns the numbers of
+// the SRV data as JSON numbers, so they are compared as float64.
+func (s *SRVConfig) changes(cnameFull string, record dnsRecord) []string {
+    want := s.data(cnameFull)
+    have, _ := record.Data.(map[string]interface{})
+
+    var changes []string
+    for _, key := range []string{"priority", "weight", "port"} {
+        wantValue := float64(want[key].(int))
+        haveValue, _ := have[key].(float64)
+        if wantValue != haveValue {
+            changes = append(changes, fmt.Sprintf("%s %v -> %v", key, have[key], want[key]))
+        }
+    }
+    haveTarget, _ := have["target"].(string)
+    if !strings.EqualFold(strings.TrimSuffix(haveTarget, "."), strings.TrimSuffix(want["target"].(string), ".")) {
+        changes = append(changes, fmt.Sprintf("target %s -> %s", haveTarget, want["target"]))
+    }
+    name := strings.Join([]string{s.Service, s.Proto, cnameFull}, ".")
+    if !strings.EqualFold(record.Name, name) {
+        changes = append(changes, fmt.Sprintf("name %s -> %s", record.Name, name))
+    }
+    return changes
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log/slog"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+)
+
+// State is what gddns learns while running, kept in state.json next to the
+// config so the config file is only rewritten when the records themselves
+// change. Records are keyed by their full name.
+type State struct {
+    Records map[string]*recordState `json:"records"`
+}
+
+type recordState struct {
+    LastIP      string     `json:"last_ip,omitempty"`
+    LastIPv6    string     `json:"last_ip_v6,omitempty"`
+    LastSuccess *time.Time `json:"last_success,omitempty"`
+
+    // LastUpdate and LastUpdateV6 are when gddns last changed the A and AAAA
+    // record, for min_update_interval.
+    LastUpdate   *time.Time `json:"last_update,omitempty"`
+    LastUpdateV6 *time.Time `json:"last_update_v6,omitempty"`
+
+    // The IDs last resolved for the record, reused when the config leaves
+    // them out, e.g. a config read from stdin, see useCachedIDs.
+    ZoneID     string `json:"zone_id,omitempty"`
+    RecordID   string `json:"record_id,omitempty"`
+    RecordIDv6 string `json:"record_id_v6,omitempty"`
+}
+
+// The state is shared by every config of --config-dir, which may update
+// their records concurrently.
+var (
+    stateMu    sync.Mutex
+    state      *State
+    stateDirty bool
+)
+
+func statePath() string {
+    return filepath.Join(configHome(), "state.json")
+}
+
+// loadState reads state.json the first time it is needed, a missing file
+// being an empty state. The caller holds stateMu.
+func loadState() error {
+    if state != nil {
+        return nil
+    }
+
+    loaded := &State{}
+    data, err := os.ReadFile(statePath())
+    if err != nil && !errors.Is(err, os.ErrNotExist) {
+        return err
+    }
+    if err == nil {
+        if err := json.Unmarshal(data, loaded); err != nil {
+            return fmt.Errorf("parsing %s: %w", statePath(), err)
+        }
+    }
+    if loaded.Records == nil {
+        loaded.Records = make(map[string]*recordState)
+    }
+    state = loaded
+    return nil
+}
+
+// adoptLegacyState moves the last_ip values older configs kept in the
+// config file into the state, unless the state already knows the record.
+func adoptLegacyState(config *Config) error {
+    stateMu.Lock()
+    defer stateMu.Unlock()
+    if err := loadState(); err != nil {
+        return err
+    }
+
+    for _, rec := range config.Records {
+        if rec.LastIP == "" && rec.LastIPv6 == "" {
+            continue
+        }
+        if _, ok := state.Records[rec.fqdn()]; !ok {
+            state.Records[rec.fqdn()] = &recordState{LastIP: rec.LastIP, LastIPv6: rec.LastIPv6}
+            stateDirty = true
+        }
+        rec.LastIP, rec.LastIPv6 = "", ""
+    }
+    return nil
+}
+
+// useCachedIDs fills in the zone and record IDs the config leaves out from
+// the ones resolved on an earlier run, saving the zone and record lookups.
+// They are not checked up front: a record ID that is gone heals like any
+// other, and a zone ID marked cachedZone is looked up again once the API no
+// longer knows it.
+func useCachedIDs(config *Config) {
+    stateMu.Lock()
+    defer stateMu.Unlock()
+    if state == nil {
+        return
+    }
+
+    for _, rec := range config.Records {
+        rs := state.Records[rec.fqdn()]
+        if rs == nil || rs.ZoneID == "" || (rec.ZoneID != "" && rec.ZoneID != rs.ZoneID) {
+            continue
+        }
+        if rec.ZoneID == "" {
+            rec.ZoneID = rs.ZoneID
+            rec.cachedZone = true
+        }
+        if rec.RecordID == "" {
+            rec.RecordID = rs.RecordID
+        }
+        if rec.RecordIDv6 == "" {
+            rec.RecordIDv6 = rs.RecordIDv6
+        }
+        slog.Debug("using cached IDs", "record", rec.CNAME, "zone_id", rec.ZoneID,
+            "record_id", rec.RecordID, "record_id_v6", rec.RecordIDv6)
+    }
+}
+
+// dropCachedZone forgets a cached zone ID the API no longer knows, and the
+// record IDs cached with it, so the zone is looked up again by name.
+func (r *Record) dropCachedZone() {
+    slog.Warn("cached zone ID no longer exists, looking the zone up again", "record", r.CNAME, "zone_id", r.ZoneID)
+    r.ZoneID, r.RecordID, r.RecordIDv6, r.cachedZone = "", "", "", false
+}
+
+// cacheIDs keeps the current zone and record IDs of every record in the
+// state for useCachedIDs.
+func cacheIDs(config *Config) {
+    stateMu.Lock()
+    defer stateMu.Unlock()
+    if state == nil {
+        state = &State{Records: make(map[string]*recordState)}
+    }
+
+    for _, rec := range config.Records {
+        rs := state.Records[rec.fqdn()]
+        if rs == nil {
+            if rec.ZoneID == "" {
+                continue
+            }
+            rs = &recordState{}
+            state.Records[rec.fqdn()] = rs
+        }
+        if rs.ZoneID != rec.ZoneID || rs.RecordID != rec.RecordID || rs.RecordIDv6 != rec.RecordIDv6 {
+            rs.ZoneID, rs.RecordID, rs.RecordIDv6 = rec.ZoneID, rec.RecordID, rec.RecordIDv6
+            stateDirty = true
+        }
+    }
+}
+
+// saveState writes state.json if anything in it changed.
+func saveState() error {
+    stateMu.Lock()
+    defer stateMu.Unlock()
+    if !stateDirty || state == nil {
+        return nil
+    }
+    if dryRun {
+        slog.Info("dry run, not saving state")
+        return nil
+    }
+
+    data, err := json.MarshalIndent(state, "", "  ")
+    if err != nil {
+        return err
+    }
+    if err := writeFileAtomic(statePath(), data, 0600); err != nil {
+        return err
+    }
+    stateDirty = false
+    return nil
+}
+
+// saveChanges saves the state and, if its records changed, the config.
+func saveChanges(config *Config) error {
+    cacheIDs(config)
+    if err := saveState(); err != nil {
+        return fmt.Errorf("saving state: %w", err)
+    }
+    return saveConfig(config)
+}
+
+// lastIP returns the address last successfully pushed to the recordType record.
+func (r *Record) lastIP(recordType string) string {
+    stateMu.Lock()
+    defer stateMu.Unlock()
+    if state == nil || state.Records[r.fqdn()] == nil {
+        return ""
+    }
+    if recordType == "AAAA" {
+        return state.Records[r.fqdn()].LastIPv6
+    }
+    return state.Records[r.fqdn()].LastIP
+}
+
+// setLastIP records that the recordType record points at ip, which also
+// counts as its last success. An empty ip forgets the address.
+func (r *Record) setLastIP(recordType, ip string) {
+    stateMu.Lock()
+    defer stateMu.Unlock()
+    if state == nil {
+        state = &State{Records: make(map[string]*recordState)}
+    }
+    rs := state.Records[r.fqdn()]
+    if rs == nil {
+        rs = &recordState{}
+        state.Records[r.fqdn()] = rs
+    }
+
+    if recordType == "AAAA" {
+        rs.LastIPv6 = ip
+    } else {
+        rs.LastIP = ip
+    }
+    if ip != "" {
+        now := time.Now().UTC()
+        rs.LastSuccess = &now
+    }
+    stateDirty = true
+}
+
+// lastUpdate returns when gddns last changed the recordType record, the zero
+// time if it never did.
+func (r *Record) lastUpdate(recordType string) time.Time {
+    stateMu.Lock()
+    defer stateMu.Unlock()
+    if state == nil || state.Records[r.fqdn()] == nil {
+        return time.Time{}
+    }
+    last := state.Records[r.fqdn()].LastUpdate
+    if recordType == "AAAA" {
+        last = state.Records[r.fqdn()].LastUpdateV6
+    }
+    if last == nil {
+        return time.Time{}
+    }
+    return *last
+}
+
+// setLastUpdate records that the recordType record was changed just now.
+func (r *Record) setLastUpdate(recordType string) {
+    stateMu.Lock()
+    defer stateMu.Unlock()
+    if state == nil {
+        state = &State{Records: make(map[string]*recordState)}
+    }
+    rs := state.Records[r.fqdn()]
+    if rs == nil {
+        rs = &recordState{}
+        state.Records[r.fqdn()] = rs
+    }
+
+    now := time.Now().UTC()
+    if recordType == "AAAA" {
+        rs.LastUpdateV6 = &now
+    } else {
+        rs.LastUpdate = &now
+    }
+    stateDirty = true
+}
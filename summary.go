@@ -0,0 +1,52 @@
+package main
+
+import (
+    "log/slog"
+    "sync"
+    "time"
+)
+
+// outcome is what a run did with one address record.
+type outcome string
+
+const (
+    outcomeCreated   outcome = "created"
+    outcomeUpdated   outcome = "updated"
+    outcomeUnchanged outcome = "unchanged"
+    outcomeSkipped   outcome = "skipped"
+    outcomeFailed    outcome = "failed"
+)
+
+// runSummary counts the outcome of every address record checked in a run or
+// daemon cycle. Records are updated concurrently, so it is locked.
+type runSummary struct {
+    mu     sync.Mutex
+    start  time.Time
+    counts map[outcome]int
+}
+
+func newRunSummary() *runSummary {
+    return &runSummary{start: time.Now(), counts: make(map[outcome]int)}
+}
+
+func (s *runSummary) add(outcomes ...outcome) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    for _, o := range outcomes {
+        s.counts[o]++
+    }
+}
+
+// log writes the one line summary of the run, e.g. for grepping journald.
+func (s *runSummary) log() {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    checked := 0
+    for _, n := range s.counts {
+        checked += n
+    }
+    slog.Info("update summary", "checked", checked, "created", s.counts[outcomeCreated], "updated", s.counts[outcomeUpdated],
+        "unchanged", s.counts[outcomeUnchanged], "skipped", s.counts[outcomeSkipped], "failed", s.counts[outcomeFailed],
+        "duration_ms", durationMS(s.start))
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "net/url"
+    "os"
+    "strings"
+    "time"
+)
+
+// httpTransport returns the transport used for every outbound request, to
+// the IP providers, Cloudflare, the webhook and the verify resolver alike.
+// http_proxy wins over HTTP_PROXY, HTTPS_PROXY and NO_PROXY.
+func (c *Config) httpTransport() http.RoundTripper {
+    transport := http.DefaultTransport.(*http.Transport).Clone()
+    transport.Proxy = http.ProxyFromEnvironment
+    if c.HTTPProxy != "" {
+        // Validated on load
+        proxy, _ := url.Parse(c.HTTPProxy)
+        transport.Proxy = http.ProxyURL(proxy)
+    }
+    return &userAgentTransport{base: transport, userAgent: c.userAgent()}
+}
+
+// userAgent returns user_agent, or gddns/<version>.
+func (c *Config) userAgent() string {
+    if c.UserAgent != "" {
+        return c.UserAgent
+    }
+    return "gddns/" + version
+}
+
+// userAgentTransport sets the User-Agent of every request, replacing the Go
+// and cloudflare-go defaults that some IP providers block.
+type userAgentTransport struct {
+    base      http.RoundTripper
+    userAgent string
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    // RoundTrippers must not modify the caller's request
+    req = req.Clone(req.Context())
+    req.Header.Set("User-Agent", t.userAgent)
+    return t.base.RoundTrip(req)
+}
+
+// newHTTPClient returns a client for the API and IP provider requests, which
+// go through withRetry and so need transientTransport.
+func newHTTPClient(config *Config, timeout time.Duration) *http.Client {
+    return &http.Client{Timeout: timeout, Transport: &transientTransport{base: config.httpTransport()}}
+}
+
+// validateProxyURL checks an http_proxy value.
+func validateProxyURL(raw string) error {
+    u, err := url.Parse(raw)
+    if err != nil || u.Host == "" {
+        return fmt.Errorf("invalid http_proxy %q, expected a URL such as \"http://proxy.example.com:3128\"", raw)
+    }
+    switch u.Scheme {
+    case "http", "https", "socks5":
+    default:
+        return fmt.Errorf("invalid http_proxy %q, the scheme must be http, https or socks5", raw)
+    }
+    return nil
+}
+
+// checkProxyEnv fails early on a malformed proxy environment variable, which
+// net/http would otherwise only report on the first request.
+func checkProxyEnv() error {
+    for _, name := range []string{"HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy"} {
+        value := os.Getenv(name)
+        if value == "" {
+            continue
+        }
+        // Like net/http, a bare host:port means an http proxy
+        if !strings.Contains(value, "://") {
+            value = "http://" + value
+        }
+        if u, err := url.Parse(value); err != nil || u.Host == "" {
+            return fmt.Errorf("invalid %s %q, expected a URL such as \"http://proxy.example.com:3128\"", name, os.Getenv(name))
+        }
+    }
+    return nil
+}
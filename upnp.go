@@ -0,0 +1,109 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "github.com/huin/goupnp/dcps/internetgateway2"
+    "log/slog"
+    "net"
+    "strings"
+)
+
+// externalIPClient is the part of the UPnP WAN connection services gddns
+// uses, they all have it.
+type externalIPClient interface {
+    GetExternalIPAddressCtx(ctx context.Context) (string, error)
+}
+
+// upnpIPProvider asks the local gateway for its WAN address over UPnP IGD.
+// IGD only knows the IPv4 address of the gateway.
+type upnpIPProvider struct {
+    allowPrivate bool
+}
+
+func (p *upnpIPProvider) PublicIP(ctx context.Context) (string, error) {
+    clients, err := discoverGateways(ctx)
+    if err != nil {
+        return "", fmt.Errorf("upnp: %w", err)
+    }
+
+    var failures []string
+    for _, client := range clients {
+        ip, err := client.GetExternalIPAddressCtx(ctx)
+        if err == nil {
+            err = p.check(ip)
+        }
+        if err != nil {
+            failures = append(failures, err.Error())
+            continue
+        }
+        return ip, nil
+    }
+    return "", fmt.Errorf("upnp: no gateway returned a usable address: %s", strings.Join(failures, "; "))
+}
+
+// check rejects what gateways return while the WAN link is down, usually an
+// empty string or 0.0.0.0, and the private address of a gateway behind
+// another NAT.
+func (p *upnpIPProvider) check(ip string) error {
+    parsed := net.ParseIP(ip)
+    if parsed == nil || parsed.IsUnspecified() {
+        return fmt.Errorf("gateway returned %q, is the WAN link down?", ip)
+    }
+    if err := checkAddressFamily("A", ip); err != nil {
+        return err
+    }
+    if !p.allowPrivate && isPrivateIP(ip) {
+        return fmt.Errorf("gateway WAN address %s is private, the gateway is behind another NAT", ip)
+    }
+    return nil
+}
+
+// discoverGateways finds the WAN connection services on the local network,
+// trying the service versions from newest to oldest and PPP connections last.
+func discoverGateways(ctx context.Context) ([]externalIPClient, error) {
+    var clients []externalIPClient
+
+    ip2, _, err := internetgateway2.NewWANIPConnection2ClientsCtx(ctx)
+    for _, client := range ip2 {
+        clients = append(clients, client)
+    }
+    if len(clients) == 0 && err == nil {
+        var ip1 []*internetgateway2.WANIPConnection1
+        ip1, _, err = internetgateway2.NewWANIPConnection1ClientsCtx(ctx)
+        for _, client := range ip1 {
+            clients = append(clients, client)
+        }
+    }
+    if len(clients) == 0 && err == nil {
+        var ppp []*internetgateway2.WANPPPConnection1
+        ppp, _, err = internetgateway2.NewWANPPPConnection1ClientsCtx(ctx)
+        for _, client := range ppp {
+            clients = append(clients, client)
+        }
+    }
+
+    if err != nil {
+        return nil, fmt.Errorf("discovering gateways: %w", err)
+    }
+    if len(clients) == 0 {
+        return nil, errors.New("no UPnP internet gateway found on the local network")
+    }
+    return clients, nil
+}
+
+// fallbackIPProvider uses fallback whenever primary fails.
+type fallbackIPProvider struct {
+    primary  IPProvider
+    fallback IPProvider
+}
+
+func (p *fallbackIPProvider) PublicIP(ctx context.Context) (string, error) {
+    ip, err := p.primary.PublicIP(ctx)
+    if err == nil {
+        return ip, nil
+    }
+    slog.Warn("IP lookup failed, falling back to the public IP providers", "error", err)
+    return p.fallback.PublicIP(ctx)
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "github.com/robfig/cron/v3"
+    "log/slog"
+    "net"
+    "net/url"
+    "sort"
+    "strings"
+    "text/template"
+    "time"
+)
+
+// Validate checks the whole config and reports every problem it finds in a
+// single error, so a broken config fails before any API call is made.
+func (c *Config) Validate() error {
+    var problems []string
+    add := func(format string, args ...any) {
+        problems = append(problems, fmt.Sprintf(format, args...))
+    }
+
+    if len(c.Records) == 0 {
+        add("no records configured")
+    }
+    if !validTTL(c.TTL) {
+        add("invalid ttl %d, expected \"auto\" or a value within 60-86400; it applies to every record without its own ttl", c.TTL)
+    }
+    if !validTTL(c.SRVTTL) {
+        add("invalid srv_ttl %d, expected \"auto\" or a value within 60-86400", c.SRVTTL)
+    }
+
+    for i, rec := range c.Records {
+        switch {
+        case rec.Domain == "":
+            add("records[%d]: domain must be set", i)
+        case !validHostname(rec.Domain):
+            add("records[%d]: domain %q is not a valid hostname", i, rec.Domain)
+        }
+        switch {
+        case rec.CNAME == "":
+            add("records[%d]: cname must be set, use \"@\" for the domain itself", i)
+        case rec.CNAME == "@", rec.CNAME == "*":
+        case !validName(strings.TrimPrefix(rec.CNAME, "*.")):
+            add("records[%d]: cname %q is not a valid DNS label, \"@\" or \"*\"", i, rec.CNAME)
+        }
+        if rec.wildcard() && (rec.srv() != nil || len(rec.Static) > 0) {
+            add("records[%d]: a wildcard record cannot have srv or static records, they would be named below the wildcard", i)
+        }
+        if !validTTL(rec.TTL) {
+            add("records[%d]: invalid ttl %d, expected \"auto\" or a value within 60-86400; omit it to use the global ttl (default %d)", i, rec.TTL, defaultTTL)
+        }
+        if !validTTL(rec.SRVTTL) {
+            add("records[%d]: invalid srv_ttl %d, expected \"auto\" or a value within 60-86400", i, rec.SRVTTL)
+        }
+        switch rec.Type {
+        case "", "A", "AAAA", "both":
+            if rec.CNAMETarget != "" {
+                add("records[%d]: cname_target is only used with type \"CNAME\"", i)
+            }
+        case "CNAME":
+            switch {
+            case rec.CNAMETarget == "":
+                add("records[%d]: cname_target must be set for a CNAME record", i)
+            case net.ParseIP(rec.CNAMETarget) != nil:
+                // Cloudflare has the final say, but this is almost certainly a mistake
+                slog.Warn("cname_target is an IP address, a CNAME should point at a hostname", "record", rec.CNAME, "cname_target", rec.CNAMETarget)
+            case !validHostname(rec.CNAMETarget):
+                add("records[%d]: cname_target %q is not a valid hostname", i, rec.CNAMETarget)
+            }
+        default:
+            add("records[%d]: unsupported type %q, expected \"A\", \"AAAA\", \"both\" or \"CNAME\"", i, rec.Type)
+        }
+        if _, ok := srvPresets[rec.SRVPreset]; rec.SRVPreset != "" && !ok {
+            add("records[%d]: unknown srv_preset %q, expected one of %s", i, rec.SRVPreset, strings.Join(srvPresetNames(), ", "))
+        } else if rec.srv() != nil {
+            if err := rec.srv().validate(); err != nil {
+                add("records[%d]: %v", i, err)
+            }
+        }
+        if rec.Credentials != "" {
+            if _, ok := c.Credentials[rec.Credentials]; !ok {
+                add("records[%d]: unknown credentials %q, not defined in credentials", i, rec.Credentials)
+            }
+        }
+        for j, static := range rec.Static {
+            if err := static.validate(); err != nil {
+                add("records[%d].static[%d]: %v", i, j, err)
+            }
+        }
+    }
+
+    names := make([]string, 0, len(c.Credentials))
+    for name := range c.Credentials {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        switch provider := c.Credentials[name].Provider; provider {
+        case "", providerCloudflare, providerDigitalOcean:
+        default:
+            add("credentials[%q]: unknown provider %q, expected \"cloudflare\" or \"digitalocean\"", name, provider)
+        }
+        if err := c.Credentials[name].validate(c.provider(name)); err != nil {
+            add("credentials[%q]: %v", name, err)
+        }
+    }
+    switch c.Provider {
+    case "", providerCloudflare, providerDigitalOcean:
+    default:
+        add("unknown provider %q, expected \"cloudflare\" or \"digitalocean\"", c.Provider)
+    }
+
+    if c.Interval != "" {
+        if _, err := time.ParseDuration(c.Interval); err != nil {
+            add("invalid interval %q, expected a duration such as \"5m\"", c.Interval)
+        }
+    }
+    if c.Cron != "" {
+        if _, err := cron.ParseStandard(c.Cron); err != nil {
+            add("invalid cron %q, expected a cron expression such as \"0 * * * *\": %v", c.Cron, err)
+        }
+        if c.Interval != "" {
+            add("interval and cron cannot both be set, the daemon runs on one or the other")
+        }
+    }
+    if c.IPCacheTTL != "" {
+        if ttl, err := time.ParseDuration(c.IPCacheTTL); err != nil || ttl < 0 {
+            add("invalid ip_cache_ttl %q, expected a duration such as \"1m\", or \"0s\" to disable the cache", c.IPCacheTTL)
+        }
+    }
+    if c.ForceInterval != "" {
+        if interval, err := time.ParseDuration(c.ForceInterval); err != nil || interval < 0 {
+            add("invalid force_interval %q, expected a duration such as \"1h\"", c.ForceInterval)
+        }
+    }
+    if c.MinUpdateInterval != "" {
+        if interval, err := time.ParseDuration(c.MinUpdateInterval); err != nil || interval < 0 {
+            add("invalid min_update_interval %q, expected a duration such as \"10m\"", c.MinUpdateInterval)
+        }
+    }
+    if c.StartupJitter != "" {
+        if jitter, err := time.ParseDuration(c.StartupJitter); err != nil || jitter < 0 {
+            add("invalid startup_jitter %q, expected a duration such as \"30s\"", c.StartupJitter)
+        }
+    }
+    if c.RequestTimeout != "" {
+        if timeout, err := time.ParseDuration(c.RequestTimeout); err != nil || timeout <= 0 {
+            add("invalid request_timeout %q, expected a positive duration such as \"10s\"", c.RequestTimeout)
+        }
+    }
+    if c.RetryAttempts < 0 {
+        add("invalid retry_attempts %d, expected a positive number", c.RetryAttempts)
+    }
+    if c.RetryBaseDelay != "" {
+        if delay, err := time.ParseDuration(c.RetryBaseDelay); err != nil || delay <= 0 {
+            add("invalid retry_base_delay %q, expected a positive duration such as \"1s\"", c.RetryBaseDelay)
+        }
+    }
+    if c.CFAPIBaseURL != "" {
+        if err := validateBaseURL(c.CFAPIBaseURL); err != nil {
+            add("%v", err)
+        }
+    }
+    if c.Tags != nil {
+        for i, tag := range *c.Tags {
+            if strings.TrimSpace(tag) == "" {
+                add("tags[%d]: tag must not be empty", i)
+            }
+        }
+    }
+    if c.HTTPProxy != "" {
+        if err := validateProxyURL(c.HTTPProxy); err != nil {
+            add("%v", err)
+        }
+    }
+    if c.Concurrency < 0 {
+        add("invalid concurrency %d, expected a positive number", c.Concurrency)
+    }
+    if c.RateLimit < 0 {
+        add("invalid rate_limit %g, expected requests per second above 0", c.RateLimit)
+    }
+    if name, ok := interfaceName(c.IPSource); ok {
+        if _, err := net.InterfaceByName(name); err != nil {
+            add("ip_source %q: %v", c.IPSource, err)
+        }
+    } else if c.IPSource != "" && c.IPSource != "public" && c.IPSource != "upnp" {
+        add("invalid ip_source %q, expected \"public\", \"upnp\" or \"interface:<name>\"", c.IPSource)
+    }
+    if c.IPSourceStrict && c.IPSource != "upnp" {
+        add("ip_source_strict is only used with ip_source \"upnp\"")
+    }
+    if c.IPv6Suffix != "" {
+        suffix := net.ParseIP(c.IPv6Suffix)
+        _, isInterface := interfaceName(c.IPSource)
+        switch {
+        case suffix == nil || suffix.To4() != nil || suffix.IsUnspecified():
+            add("invalid ipv6_suffix %q, expected the host part of an IPv6 address such as \"::1234\"", c.IPv6Suffix)
+        case !isInterface:
+            add("ipv6_suffix is only used with ip_source \"interface:<name>\"")
+        }
+    }
+    for _, name := range c.IPProviders {
+        if _, ok := ipEndpoint(name, "A"); !ok {
+            add("unknown IP provider %q in ip_providers, expected a provider name or an http(s) URL", name)
+        }
+    }
+    for _, cidr := range c.IPAllowlist {
+        if _, _, err := net.ParseCIDR(cidr); err != nil {
+            add("invalid ip_allowlist range %q, expected a CIDR such as \"203.0.113.0/24\"", cidr)
+        }
+    }
+    for _, cidr := range c.IPDenylist {
+        if _, _, err := net.ParseCIDR(cidr); err != nil {
+            add("invalid ip_denylist range %q, expected a CIDR such as \"203.0.113.0/24\"", cidr)
+        }
+    }
+
+    if c.NotifyWebhook != "" {
+        if u, err := url.Parse(c.NotifyWebhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+            add("invalid notify_webhook %q, expected an http(s) URL", c.NotifyWebhook)
+        }
+    }
+    if len(c.OnChangeCommand) > 0 && c.OnChangeCommand[0] == "" {
+        add("on_change_command must start with the program to run")
+    }
+    if c.VerifyTimeout != "" {
+        if timeout, err := time.ParseDuration(c.VerifyTimeout); err != nil || timeout <= 0 {
+            add("invalid verify_timeout %q, expected a positive duration such as \"2m\"", c.VerifyTimeout)
+        }
+    }
+    if c.VerifyResolver != "" {
+        if u, err := url.Parse(c.VerifyResolver); err != nil || u.Scheme != "https" || u.Host == "" {
+            add("invalid verify_resolver %q, expected an https URL", c.VerifyResolver)
+        }
+    }
+    if (c.MetricsTLSCert == "") != (c.MetricsTLSKey == "") {
+        add("metrics_tls_cert and metrics_tls_key must be set together")
+    }
+    if c.MetricsTLSClientCA != "" && c.MetricsTLSCert == "" {
+        add("metrics_tls_client_ca needs metrics_tls_cert and metrics_tls_key to be set")
+    }
+    if c.LogMaxSize < 0 {
+        add("invalid log_max_size %d, expected a size in megabytes", c.LogMaxSize)
+    }
+    if c.LogMaxBackups < 0 {
+        add("invalid log_max_backups %d, expected a number of files to keep", c.LogMaxBackups)
+    }
+    if c.LogFile == "" && (c.LogMaxSize != 0 || c.LogMaxBackups != 0 || c.LogStderr) {
+        add("log_max_size, log_max_backups and log_stderr need log_file to be set")
+    }
+    if c.CommentTemplate != nil {
+        if _, err := template.New("comment_template").Parse(*c.CommentTemplate); err != nil {
+            add("invalid comment_template: %v", err)
+        }
+    }
+    if c.NotifyTemplate != "" {
+        if _, err := template.New("notify_template").Parse(c.NotifyTemplate); err != nil {
+            add("invalid notify_template: %v", err)
+        }
+    }
+
+    if len(problems) == 0 {
+        return nil
+    }
+    return errors.New("invalid config: " + strings.Join(problems, "; "))
+}
+
+// validLabel reports whether s is a single DNS label as allowed in hostnames.
+func validLabel(s string) bool {
+    if len(s) == 0 || len(s) > 63 || s[0] == '-' || s[len(s)-1] == '-' {
+        return false
+    }
+    for _, r := range s {
+        if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+            return false
+        }
+    }
+    return true
+}
+
+// validName reports whether s is one or more dot separated labels.
+func validName(s string) bool {
+    if len(s) > 253 {
+        return false
+    }
+    for _, label := range strings.Split(s, ".") {
+        if !validLabel(label) {
+            return false
+        }
+    }
+    return true
+}
+
+// validHostname reports whether s is a fully qualified name such as
+// example.com, with or without a trailing dot.
+func validHostname(s string) bool {
+    s = strings.TrimSuffix(s, ".")
+    return strings.Contains(s, ".") && validName(s)
+}
+
+// validateBaseURL checks a cf_api_base_url or CF_API_BASE_URL value.
+func validateBaseURL(raw string) error {
+    if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+        return fmt.Errorf("invalid cf_api_base_url %q, expected an http(s) URL such as \"https://api.cloudflare.com/client/v4\"", raw)
+    }
+    return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "net/url"
+    "time"
+)
+
+const (
+    defaultVerifyResolver = "https://cloudflare-dns.com/dns-query"
+    verifyPollInterval    = 5 * time.Second
+)
+
+// dohResponse is the part of the DNS JSON API response we look at.
+type dohResponse struct {
+    Status int `json:"Status"`
+    Answer []struct {
+        Type int    `json:"type"`
+        Data string `json:"data"`
+    } `json:"Answer"`
+}
+
+// verifyTimeout returns how long to wait for an update to show up in public
+// DNS. Zero means verification is disabled.
+func (c *Config) verifyTimeout() time.Duration {
+    timeout, err := time.ParseDuration(c.VerifyTimeout)
+    if err != nil || timeout <= 0 {
+        return 0
+    }
+    return timeout
+}
+
+func (c *Config) verifyResolver() string {
+    if c.VerifyResolver != "" {
+        return c.VerifyResolver
+    }
+    return defaultVerifyResolver
+}
+
+// verifyPropagation polls a DNS-over-HTTPS resolver until the recordType
+// record of rec resolves to ip or verify_timeout runs out. Resolver caches
+// vary, so not seeing the update is only worth a warning.
+func verifyPropagation(ctx context.Context, config *Config, rec *Record, recordType, ip string) {
+    timeout := config.verifyTimeout()
+    if timeout == 0 || dryRun || recordType == "CNAME" {
+        return
+    }
+    if config.recordProxied(rec) {
+        slog.Debug("not verifying a proxied record, it resolves to Cloudflare addresses", "record", rec.CNAME, "type", recordType)
+        return
+    }
+
+    ctx, cancel := context.WithTimeout(ctx, timeout)
+    defer cancel()
+
+    client := &http.Client{Timeout: config.requestTimeout(), Transport: config.httpTransport()}
+    start := time.Now()
+    var lastErr error
+    for {
+        answers, err := queryDoH(ctx, client, config.verifyResolver(), rec.fqdn(), recordType)
+        if err == nil {
+            for _, answer := range answers {
+                if answer == ip {
+                    logEvent("record_verified", "DNS record update visible in public DNS", "record", rec.CNAME,
+                        "type", recordType, "ip", ip, "duration_ms", durationMS(start))
+                    return
+                }
+            }
+            err = fmt.Errorf("resolver returned %v", answers)
+        }
+        lastErr = err
+
+        select {
+        case <-ctx.Done():
+            slog.Warn("DNS record update not visible in public DNS yet", "record", rec.CNAME, "type", recordType,
+                "ip", ip, "waited", timeout, "error", lastErr)
+            return
+        case <-time.After(verifyPollInterval):
+        }
+    }
+}
+
+// queryDoH looks up name with the DNS JSON API of resolver and returns the
+// addresses in the answer.
+func queryDoH(ctx context.Context, client *http.Client, resolver, name, recordType string) ([]string, error) {
+    query := url.Values{"name": {name}, "type": {recordType}}
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, resolver+"?"+query.Encode(), nil)
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Accept", "application/dns-json")
+
+    resp, err := client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("unexpected status %s from %s", resp.Status, resolver)
+    }
+
+    var answer dohResponse
+    if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+        return nil, fmt.Errorf("decoding response from %s: %w", resolver, err)
+    }
+    if answer.Status != 0 {
+        return nil, fmt.Errorf("resolver returned DNS status %d", answer.Status)
+    }
+
+    var addresses []string
+    for _, a := range answer.Answer {
+        // 1 is A and 28 is AAAA, skip the CNAMEs along the way
+        if a.Type == 1 || a.Type == 28 {
+            addresses = append(addresses, a.Data)
+        }
+    }
+    return addresses, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+    "fmt"
+    "runtime"
+    "runtime/debug"
+)
+
+// Build metadata, set with e.g.
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%FT%TZ)"
+var (
+    version   = "dev"
+    commit    = ""
+    buildDate = ""
+)
+
+// versionString describes the running binary. Without ldflags the commit and
+// date fall back to the revision and commit time the Go toolchain embedded.
+func versionString() string {
+    rev, date := commit, buildDate
+    if info, ok := debug.ReadBuildInfo(); ok {
+        for _, setting := range info.Settings {
+            switch {
+            case setting.Key == "vcs.revision" && rev == "":
+                rev = setting.Value
+            case setting.Key == "vcs.time" && date == "":
+                date = setting.Value
+            }
+        }
+    }
+    if rev == "" {
+        rev = "unknown"
+    }
+    if date == "" {
+        date = "unknown"
+    }
+
+    return fmt.Sprintf("gddns %s (commit %s, built %s, %s)", version, rev, date, runtime.Version())
+}
+
+func versionCommand(args []string) error {
+    fmt.Println(versionString())
+    return nil
+}
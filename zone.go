@@ -0,0 +1,100 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log/slog"
+    "strings"
+    "sync"
+)
+
+// resolveZoneID looks up the zone ID for rec.Domain, listing the candidates
+// when the name is ambiguous.
+func resolveZoneID(ctx context.Context, api DNSProvider, config *Config, rec *Record) error {
+    var zones []dnsZone
+    err := withRetry(ctx, config, "list zones", func(ctx context.Context) error {
+        var err error
+        zones, err = api.FindZones(ctx, rec.Domain)
+        return err
+    })
+    if err != nil {
+        return fmt.Errorf("error looking up zone %s: %w", rec.Domain, err)
+    }
+
+    switch len(zones) {
+    case 0:
+        return fmt.Errorf("no zone named %s is visible to these credentials", rec.Domain)
+    case 1:
+        rec.ZoneID = zones[0].ID
+        slog.Debug("resolved zone", "domain", rec.Domain, "zone_id", rec.ZoneID, "account", zones[0].Account)
+        return nil
+    }
+
+    candidates := make([]string, 0, len(zones))
+    for _, zone := range zones {
+        candidates = append(candidates, fmt.Sprintf("%s (account %s)", zone.ID, zone.Account))
+    }
+    return fmt.Errorf("ambiguous zone name %s, set zone_id to one of: %s", rec.Domain, strings.Join(candidates, ", "))
+}
+
+// zoneNames caches the zone names checkZone looked up, a zone keeps its name
+// for as long as the daemon runs.
+var zoneNames = struct {
+    sync.Mutex
+    names map[string]string
+}{names: make(map[string]string)}
+
+// checkZone makes sure the name of rec lies within the zone its zone_id
+// names, so a zone_id copied from another domain fails with a clear error
+// instead of a confusing one from the API. A zone that cannot be read, e.g.
+// with a token lacking zone read access, is only worth a warning.
+func checkZone(ctx context.Context, api DNSProvider, config *Config, rec *Record) error {
+    zoneNames.Lock()
+    name, ok := zoneNames.names[rec.ZoneID]
+    zoneNames.Unlock()
+
+    if !ok {
+        var zone dnsZone
+        err := withRetry(ctx, config, "get zone", func(ctx context.Context) error {
+            var err error
+            zone, err = api.GetZone(ctx, rec.ZoneID)
+            return err
+        })
+        if zoneNotFound(err) {
+            return configError(fmt.Errorf("zone %s not found, check zone_id of %s or remove it to discover the zone from the domain: %w",
+                rec.ZoneID, rec.fqdn(), err))
+        }
+        if err != nil {
+            slog.Warn("could not read the zone, not checking the record name belongs to it", "record", rec.CNAME,
+                "zone_id", rec.ZoneID, "error", err)
+            return nil
+        }
+        name = zone.Name
+        zoneNames.Lock()
+        zoneNames.names[rec.ZoneID] = name
+        zoneNames.Unlock()
+    }
+
+    fqdn := strings.ToLower(rec.fqdn())
+    name = strings.ToLower(strings.TrimSuffix(name, "."))
+    if fqdn != name && !strings.HasSuffix(fqdn, "."+name) {
+        return configError(fmt.Errorf("%s is not within zone %s (%s), check domain and zone_id of the record",
+            rec.fqdn(), name, rec.ZoneID))
+    }
+    return nil
+}
+
+// zoneNotFound reports whether err means rec's zone_id does not name a zone
+// these credentials can see.
+func zoneNotFound(err error) bool {
+    var nfErr *notFoundError
+    return errors.As(err, &nfErr) && nfErr.zone
+}
+
+// recordNotFound reports whether err means the record ID gddns has saved no
+// longer exists, e.g. because the record was deleted in the dashboard.
+func recordNotFound(err error) bool {
+    var nfErr *notFoundError
+    return errors.As(err, &nfErr) && !nfErr.zone
+}